@@ -8,17 +8,67 @@ import (
 )
 
 var (
-	initCommand = kingpin.Command("init", "Initialise stscreds. Creates ~/.stscreds/credentials.")
-	expires     = kingpin.Flag("expires", "Credentials expiry").Default("12h").Duration()
-	profile     = kingpin.Flag("profile", "AWS profile to manage credentials for.").Default("default").String()
+	initCommand    = kingpin.Command("init", "Initialise stscreds. Creates ~/.stscreds/credentials.")
+	expires        = kingpin.Flag("expires", "Credentials expiry").Default("12h").Duration()
+	profile        = kingpin.Flag("profile", "AWS profile to manage credentials for.").Default("default").Envar("AWS_PROFILE").String()
+	backups        = kingpin.Flag("backups", "Number of backups kept of each credentials file.").Default("10").Envar("STSCREDS_BACKUPS").Int()
+	minTTL         = kingpin.Flag("min-ttl", "Refresh credentials that expire sooner than this, overriding the profile's refresh_before.").Envar("STSCREDS_MIN_TTL").Duration()
+	nonInteractive = kingpin.Flag("non-interactive", "Fail instead of prompting. The default in CI.").Envar("STSCREDS_NON_INTERACTIVE").Bool()
+	pinentry       = kingpin.Flag("pinentry", "Ask for MFA tokens with this pinentry program, e.g. pinentry-gnome3.").Envar("STSCREDS_PINENTRY").String()
+	errorFormat    = kingpin.Flag("error-format", "How errors are written to stderr: text or json.").Default("text").Envar("STSCREDS_ERROR_FORMAT").Enum("text", "json")
+	permissions    = kingpin.Flag("insecure-permissions", "Whether to fix or refuse to use credential files other users can access.").Default(stscreds.FixPermissions).Envar("STSCREDS_INSECURE_PERMISSIONS").Enum(stscreds.FixPermissions, stscreds.RefusePermissions)
+
+	initCSV     = initCommand.Flag("csv", "Read keys from the accessKeys.csv downloaded from the AWS console.").ExistingFile()
+	initJSON    = initCommand.Flag("json", "Read keys from JSON on stdin, e.g. from aws iam create-access-key.").Bool()
+	initFromEnv = initCommand.Flag("from-env", "Read keys from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.").Bool()
+	initMigrate = initCommand.Flag("migrate", "Move the profile's long-term keys out of ~/.aws/credentials.").Bool()
 
 	authCommand    = kingpin.Command("auth", "Authenticates with AWS and requests a temporary session token.")
 	envVarTemplate = authCommand.Flag("output-env", "Additionally write environment variable exports to stdout.").Bool()
+	sessionPolicy  = authCommand.Flag("policy", "Session policy JSON, or file:// path, to restrict an assumed role.").String()
+	policyArns     = authCommand.Flag("policy-arn", "Managed policy ARN to restrict an assumed role. Can be repeated.").Strings()
+	readOnly       = authCommand.Flag("read-only", "Restrict an assumed role to read-only access.").Bool()
+	authAll        = authCommand.Flag("all", "Authenticate every role profile.").Bool()
+	authGroup      = authCommand.Flag("group", "Authenticate the role profiles in a group.").String()
+	mfaAttempts    = authCommand.Flag("mfa-attempts", "Number of times to ask for an MFA code that isn't accepted.").Default("3").Int()
+	parallelism    = authCommand.Flag("parallel", "Number of roles to assume at once with --all or --group.").Default("4").Int()
+
+	elevateCommand  = kingpin.Command("elevate", "Temporarily replaces a profile's credentials with those of a privileged role.")
+	elevateReason   = elevateCommand.Flag("reason", "Justification for elevated access, e.g. an incident number.").Required().String()
+	elevateDuration = elevateCommand.Flag("duration", "How long elevated access lasts, at most 1h.").Default("15m").Duration()
+
+	discoverCommand        = kingpin.Command("discover-accounts", "Generates role profiles for every account in the AWS Organization.")
+	discoverRoleName       = discoverCommand.Flag("role-name", "Name of the role to assume in each account.").Required().String()
+	discoverManagementRole = discoverCommand.Flag("management-role-arn", "Role to assume to list the organization's accounts.").String()
+	discoverPrefix         = discoverCommand.Flag("prefix", "Prefix for generated profile names.").String()
+	discoverRegion         = discoverCommand.Flag("region", "Region for generated ~/.aws/config profiles.").Default("eu-west-1").String()
+	discoverDryRun         = discoverCommand.Flag("dry-run", "Print the changes without writing them.").Bool()
+	discoverForce          = discoverCommand.Flag("force", "Overwrite profiles that already exist instead of skipping them.").Bool()
+
+	rolesCommand    = kingpin.Command("roles", "Lists the roles you're allowed to assume.")
+	rolesAsProfiles = rolesCommand.Flag("profiles", "Print role profiles for ~/.stscreds/config.").Bool()
+
+	importCommand = kingpin.Command("import", "Copies role profiles from ~/.aws/config into ~/.stscreds/config.")
+	importDryRun  = importCommand.Flag("dry-run", "Print the changes without writing them.").Bool()
+
+	exportCommand           = kingpin.Command("export-config", "Writes role profiles into ~/.aws/config.")
+	exportCredentialProcess = exportCommand.Flag("credential-process", "Write credential_process entries that call stscreds.").Bool()
+	exportDryRun            = exportCommand.Flag("dry-run", "Print the changes without writing them.").Bool()
+
+	credentialProcessCommand = kingpin.Command("credential-process", "Print credentials for use as an AWS credential_process.")
+
+	restoreCommand = kingpin.Command("restore", "Lists or restores backups of the credentials files.")
+	restoreList    = restoreCommand.Flag("list", "List the backups.").Bool()
+	restoreAt      = restoreCommand.Flag("at", "Restore the credentials files as they were at this time (RFC3339).").String()
+
+	restoreElevationsCommand = kingpin.Command("restore-elevations", "Waits for elevated access to end and restores the previous credentials.").Hidden()
 
 	readCommand = kingpin.Command("read", "Read keys from ~/.aws/credentials and print to stdout.")
 	readKey     = readCommand.Arg("key", "Key to read from credentials file: aws_access_key_id, aws_secret_access_key, aws_session_token.").String()
 
 	userCommand = kingpin.Command("whoami", "Print details about current user.")
+
+	doctorCommand = kingpin.Command("doctor", "Checks your environment, files and AWS setup, suggesting fixes.")
 )
 
 var versionNumber string
@@ -34,25 +84,100 @@ type Command interface {
 	Execute() error
 }
 
+func newAuthCommand() *stscreds.AuthCommand {
+	cmd := stscreds.DefaultAuthCommand()
+	cmd.Expiry = *expires
+	cmd.MFAAttempts = *mfaAttempts
+	if *pinentry != "" {
+		cmd.TokenReader = &stscreds.PinentryTokenReader{Program: *pinentry}
+	}
+	return cmd
+}
+
 func newCommand(command string) (Command, error) {
 	switch command {
 	case "whoami":
 		return &stscreds.WhoAmI{Profile: *profile}, nil
 	case "auth":
-		cmd := stscreds.DefaultAuthCommand()
-		cmd.Expiry = *expires
+		cmd := newAuthCommand()
 		cmd.OutputAsEnvVariable = *envVarTemplate
 		cmd.Profile = *profile
+		cmd.Policy = *sessionPolicy
+		cmd.PolicyArns = *policyArns
+		cmd.ReadOnly = *readOnly
+		cmd.All = *authAll
+		cmd.Group = *authGroup
+		cmd.Parallelism = *parallelism
 		return cmd, nil
+	case "elevate":
+		auth := newAuthCommand()
+		return &stscreds.ElevateCommand{Auth: auth, Profile: *profile, Reason: *elevateReason, Duration: *elevateDuration}, nil
+	case "restore-elevations":
+		return &stscreds.RestoreElevationsCommand{}, nil
+	case "discover-accounts":
+		auth := newAuthCommand()
+		return &stscreds.DiscoverAccountsCommand{
+			Auth:              auth,
+			SourceProfile:     *profile,
+			RoleName:          *discoverRoleName,
+			ManagementRoleArn: *discoverManagementRole,
+			ProfilePrefix:     *discoverPrefix,
+			Region:            *discoverRegion,
+			DryRun:            *discoverDryRun,
+			Force:             *discoverForce,
+		}, nil
+	case "roles":
+		auth := newAuthCommand()
+		return &stscreds.RolesCommand{Auth: auth, Profile: *profile, AsProfiles: *rolesAsProfiles}, nil
+	case "import":
+		return &stscreds.ImportCommand{DryRun: *importDryRun}, nil
+	case "export-config":
+		return &stscreds.ExportConfigCommand{CredentialProcess: *exportCredentialProcess, DryRun: *exportDryRun}, nil
+	case "credential-process":
+		ttl, err := stscreds.MinimumLifetime(*profile, *minTTL)
+		if err != nil {
+			return nil, err
+		}
+		return &stscreds.CredentialProcessCommand{Profile: *profile, MinTTL: ttl}, nil
 	case "read":
-		return &stscreds.ReadCommand{Key: *readKey, Profile: *profile}, nil
+		ttl, err := stscreds.MinimumLifetime(*profile, *minTTL)
+		if err != nil {
+			return nil, err
+		}
+		return &stscreds.ReadCommand{Key: *readKey, Profile: *profile, MinTTL: ttl}, nil
 	}
 	return nil, fmt.Errorf("Command not found: %s", command)
 }
 
+// set once credentials have been refreshed, so credentials that are still
+// too short-lived don't refresh forever
+var refreshed bool
+
 func handle(command string) error {
+	// diagnoses the problems the rest would stop at
+	if command == "doctor" {
+		cmd := &stscreds.DoctorCommand{Profile: *profile}
+		return cmd.Execute()
+	}
+
+	err := stscreds.CheckPermissions(stscreds.PermissionPolicy)
+	if err != nil {
+		return err
+	}
+
 	if command == "init" {
-		cmd := &stscreds.InitCommand{Profile: *profile}
+		cmd := &stscreds.InitCommand{
+			Profile: *profile,
+			CSVPath: *initCSV,
+			JSON:    *initJSON,
+			FromEnv: *initFromEnv,
+			Migrate: *initMigrate,
+		}
+		return cmd.Execute()
+	}
+
+	if command == "restore" {
+		cmd := &stscreds.RestoreCommand{List: *restoreList, At: *restoreAt}
 		return cmd.Execute()
 	}
 
@@ -67,7 +192,17 @@ func handle(command string) error {
 	}
 
 	if !exist {
-		return fmt.Errorf("Limited access credentials not found, please run init first.")
+		return &stscreds.Error{Kind: stscreds.ErrNotInitialised, Message: "Limited access credentials not found, please run init first."}
+	}
+
+	elevations, err := stscreds.DefaultElevations()
+	if err != nil {
+		return err
+	}
+
+	err = elevations.RestoreExpired(stscreds.Now())
+	if err != nil {
+		return err
 	}
 
 	cmd, err := newCommand(command)
@@ -81,7 +216,18 @@ func handle(command string) error {
 	}
 
 	if _, ok := err.(stscreds.ExpiredCredentialsErr); ok {
-		err = handle("auth")
+		ttl, ttlErr := stscreds.MinimumLifetime(*profile, *minTTL)
+		if ttlErr != nil {
+			return ttlErr
+		}
+		if refreshed {
+			return fmt.Errorf("new credentials for %s expire within the minimum lifetime of %s", *profile, ttl)
+		}
+		refreshed = true
+
+		err = stscreds.RefreshCredentials(*profile, ttl, func() error {
+			return handle("auth")
+		})
 		if err != nil {
 			return err
 		}
@@ -92,16 +238,34 @@ func handle(command string) error {
 }
 
 func fatal(e error) {
-	fmt.Fprintf(os.Stderr, "error: %s\n", e.Error())
-	os.Exit(2)
+	if *errorFormat == "json" {
+		os.Stderr.Write(stscreds.ErrorJSON(e))
+	} else {
+		fmt.Fprintf(os.Stderr, "error: %s\n", e.Error())
+		if hint := stscreds.ErrorHint(e); hint != "" {
+			fmt.Fprintf(os.Stderr, "hint: %s\n", hint)
+		}
+	}
+	os.Exit(stscreds.ExitCode(e))
 }
 
 func main() {
 	kingpin.Version(versionString())
 	command := kingpin.Parse()
 
+	stscreds.BackupCount = *backups
+	stscreds.PermissionPolicy = *permissions
+	stscreds.NonInteractive = *nonInteractive || stscreds.DetectNonInteractive()
+
 	err := handle(command)
 
+	// restore-elevations runs detached, so its errors would go unseen
+	if err != nil && command == "restore-elevations" {
+		if auditErr := stscreds.RecordRestoreFailure(err); auditErr != nil {
+			fmt.Fprintf(os.Stderr, "error writing audit log: %s\n", auditErr.Error())
+		}
+	}
+
 	if err != nil {
 		fatal(err)
 	}
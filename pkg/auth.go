@@ -1,11 +1,13 @@
 package stscreds
 
 import (
-	"bufio"
 	"fmt"
 	"os"
-	"strings"
 	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
 )
 
 // interface to allow other things to provide tokens
@@ -13,18 +15,54 @@ type TokenReader interface {
 	Read() (string, error)
 }
 
+// implemented by token readers that describe what the token is for, or why
+// the last one wasn't accepted
+type RequestTokenReader interface {
+	ReadRequest(request *TokenRequest) (string, error)
+}
+
+func readToken(reader TokenReader, request *TokenRequest) (string, error) {
+	if r, ok := reader.(RequestTokenReader); ok {
+		return r.ReadRequest(request)
+	}
+	return reader.Read()
+}
+
+// what a token is being asked for
+type TokenRequest struct {
+	Profile   string
+	Username  string
+	MFASerial string
+
+	// why the last token wasn't accepted, when asking again
+	Error string
+}
+
 type StdioTokenReader struct {
 }
 
 func (f *StdioTokenReader) Read() (string, error) {
-	fmt.Fprintf(os.Stderr, "Please enter MFA token: ")
+	return f.ReadRequest(&TokenRequest{})
+}
+
+func (f *StdioTokenReader) ReadRequest(request *TokenRequest) (string, error) {
+	p, err := openPrompt("an MFA token")
+	if err != nil {
+		return "", err
+	}
+	defer p.Close()
 
-	reader := bufio.NewReader(os.Stdin)
-	text, err := reader.ReadString('\n')
+	if request.Error != "" {
+		fmt.Fprintln(p.out, request.Error)
+	}
+	if request.Username != "" {
+		fmt.Fprintf(p.out, "Current user: %s. ", request.Username)
+	}
+	token, err := p.ask("Please enter MFA token: ")
 	if err != nil {
 		return "", fmt.Errorf("error reading token: %s", err.Error())
 	}
-	return strings.Trim(text, " \r\n"), nil
+	return token, nil
 }
 
 type AuthCommand struct {
@@ -32,67 +70,354 @@ type AuthCommand struct {
 	OutputAsEnvVariable bool
 	Profile             string
 	TokenReader         TokenReader
+
+	// how many MFA codes are asked for before giving up
+	MFAAttempts int
+
+	// restrict the session when assuming a role: an inline policy document
+	// (or file:// path), managed policy arns, and a read-only shortcut
+	Policy     string
+	PolicyArns []string
+	ReadOnly   bool
+
+	// authenticate every role profile, or those in a group, instead of
+	// Profile; Parallelism bounds how many roles are assumed at once
+	All         bool
+	Group       string
+	Parallelism int
 }
 
 // creates an auth command suitable for reading from stdin with a prompt
 func DefaultAuthCommand() *AuthCommand {
 	return &AuthCommand{
 		TokenReader: &StdioTokenReader{},
+		MFAAttempts: DefaultMFAAttempts,
 	}
 }
 
 func (cmd *AuthCommand) Execute() error {
+	// there's no one set of credentials to export for many roles
+	if (cmd.All || cmd.Group != "") && cmd.OutputAsEnvVariable {
+		return errorf(ErrConfig, "--output-env can't be used with --all or --group")
+	}
+
 	err := ensureAwsDir()
 	if err != nil {
 		return fmt.Errorf("Error ensuring .aws directory: %s", err)
 	}
 
-	limitedCreds, err := DefaultLimitedAccessCredentials(cmd.Profile)
+	config, err := DefaultConfig()
 	if err != nil {
 		return err
 	}
 
-	limitedAccessSession, err := limitedCreds.NewSession()
+	if cmd.All {
+		roles, err := config.RoleProfiles()
+		if err != nil {
+			return err
+		}
+		return cmd.authenticateGroup(roles)
+	}
+
+	if cmd.Group != "" {
+		roles, err := config.Group(cmd.Group)
+		if err != nil {
+			return err
+		}
+		return cmd.authenticateGroup(roles)
+	}
+
+	unlock, err := lockProfile(cmd.Profile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	role, err := config.RoleProfile(cmd.Profile)
+	if err != nil {
+		return err
+	}
+
+	// session tokens can't be restricted, so refuse rather than write
+	// credentials with more access than was asked for
+	if role == nil && (cmd.Policy != "" || len(cmd.PolicyArns) > 0 || cmd.ReadOnly) {
+		return errorf(ErrConfig, "%s isn't a role profile, so --policy, --policy-arn and --read-only can't restrict it", cmd.Profile)
+	}
+
+	var generatedCredentials *Credentials
+	if role != nil {
+		generatedCredentials, err = cmd.assumeRole(role)
+		if err == nil {
+			err = saveCredentials(role.Name, generatedCredentials, role.sessionState())
+		}
+	} else {
+		generatedCredentials, err = cmd.requestSessionToken(cmd.Profile, "")
+	}
 	if err != nil {
 		return err
 	}
+
+	if cmd.OutputAsEnvVariable {
+		envVarExportsOutput(generatedCredentials)
+	}
+
+	return nil
+}
+
+// authenticates with an MFA token and writes a new session token for profile.
+// The MFA device is looked up when mfaSerial is empty and the profile
+// doesn't configure one.
+func (cmd *AuthCommand) requestSessionToken(profile, mfaSerial string) (*Credentials, error) {
+	if NonInteractive {
+		return nil, InteractionRequiredErr("an MFA token")
+	}
+
+	limitedCreds, err := DefaultLimitedAccessCredentials(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	limitedAccessSession, err := limitedCreds.NewSession()
+	if err != nil {
+		return nil, err
+	}
 	username, err := currentUserName(limitedAccessSession)
 	if err != nil {
-		return fmt.Errorf("couldn't request current user: %s\n", err.Error())
+		return nil, awsError(err, "couldn't request current user")
 	}
 
-	fmt.Fprintf(os.Stderr, "Current user: %s. ", username)
+	if mfaSerial == "" {
+		config, err := DefaultConfig()
+		if err != nil {
+			return nil, err
+		}
+		mfaSerial, err = config.MFASerial(profile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if mfaSerial == "" {
+		mfaSerial, err = mfaSerialNumber(limitedAccessSession, username)
+		if err != nil {
+			return nil, awsError(err, "error requesting credentials")
+		}
+	}
 
-	token, err := cmd.TokenReader.Read()
+	generatedCredentials, err := cmd.sessionTokenWithMFA(limitedAccessSession, &TokenRequest{Profile: profile, Username: username, MFASerial: mfaSerial})
 	if err != nil {
-		return fmt.Errorf("error requesting mfa token: %s", err.Error())
+		return nil, err
 	}
 
-	generatedCredentials, err := requestNewSTSToken(limitedAccessSession, username, token, cmd.Expiry, cmd.Profile)
+	err = saveCredentials(profile, generatedCredentials, &SessionState{MFASerial: mfaSerial})
 	if err != nil {
-		return fmt.Errorf("error requesting credentials: %s", err.Error())
+		return nil, err
 	}
 
-	tc, err := DefaultTemporaryCredentials(cmd.Profile)
+	return generatedCredentials, nil
+}
+
+// assumes the role using the source profile's session token, authenticating
+// the source profile first if it has no valid session. The credentials are
+// returned without being saved.
+func (cmd *AuthCommand) assumeRole(role *RoleProfile) (*Credentials, error) {
+	input, err := cmd.sessionPolicies(role)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := cmd.baseSession(role.SourceProfile, role.MFASerial)
+	if err != nil {
+		return nil, err
+	}
+
+	return cmd.assumeRoleFrom(base, role, input)
+}
+
+// the MFA authenticated session of a source profile, from which roles are
+// assumed
+type baseSession struct {
+	session  *session.Session
+	username string
+}
+
+// returns the session for the profile's session token, authenticating with
+// an MFA token first if it has no valid session. The profile is locked, so
+// a session another process is requesting is waited for and reused.
+func (cmd *AuthCommand) baseSession(profile, mfaSerial string) (*baseSession, error) {
+	unlock, err := lockProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	valid, err := hasValidSession(profile, Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if !valid {
+		_, err = cmd.requestSessionToken(profile, mfaSerial)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	limitedCreds, err := DefaultLimitedAccessCredentials(profile)
+	if err != nil {
+		return nil, err
+	}
+	limitedAccessSession, err := limitedCreds.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	username, err := currentUserName(limitedAccessSession)
+	if err != nil {
+		return nil, awsError(err, "couldn't request current user")
+	}
+
+	sourceCreds, err := DefaultTemporaryCredentials(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceSession, err := sourceCreds.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	return &baseSession{session: sourceSession, username: username}, nil
+}
+
+// assumes the role from an authenticated base session; input carries the
+// role's session policies.
+func (cmd *AuthCommand) assumeRoleFrom(base *baseSession, role *RoleProfile, input *sts.AssumeRoleInput) (*Credentials, error) {
+	username := base.username
+	ctx, err := newSessionNameContext(username, role.Name, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	sessionName, err := renderSessionName(role.SessionName, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	input.RoleArn = aws.String(role.RoleArn)
+	input.RoleSessionName = aws.String(sessionName)
+	if role.ExternalID != "" {
+		input.ExternalId = aws.String(role.ExternalID)
+	}
+	input.DurationSeconds = aws.Int64(int64(role.Duration.Seconds()))
+
+	switch role.SourceIdentity {
+	case NoSourceIdentity:
+	case "":
+		input.SourceIdentity = aws.String(username)
+	default:
+		input.SourceIdentity = aws.String(role.SourceIdentity)
+	}
+
+	if len(role.Tags) > 0 {
+		input.Tags = role.sessionTags()
+		input.TransitiveTagKeys = aws.StringSlice(role.TransitiveTagKeys)
+	}
+
+	generatedCredentials, err := assumeRole(base.session, role.Region, input)
+	if err != nil {
+		return nil, awsError(err, "error assuming role %s", role.RoleArn)
+	}
+
+	return generatedCredentials, nil
+}
+
+// builds the session policies for assuming role; flags are combined with
+// those configured on the profile. Policy documents are validated before
+// any request is made to STS.
+func (cmd *AuthCommand) sessionPolicies(role *RoleProfile) (*sts.AssumeRoleInput, error) {
+	input := &sts.AssumeRoleInput{}
+
+	policy := role.Policy
+	if cmd.Policy != "" {
+		policy = cmd.Policy
+	}
+	if policy != "" {
+		doc, err := readPolicyDocument(policy)
+		if err != nil {
+			return nil, err
+		}
+		input.Policy = aws.String(doc)
+	}
+
+	arns := append([]string{}, role.PolicyArns...)
+	arns = append(arns, cmd.PolicyArns...)
+	if cmd.ReadOnly {
+		arns = append(arns, ReadOnlyPolicyArn)
+	}
+	for _, arn := range arns {
+		input.PolicyArns = append(input.PolicyArns, &sts.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+
+	return input, nil
+}
+
+// writes credentials to ~/.aws/credentials and records the session in the
+// profile's state, filling in when and what was written. Both files are
+// rolled back if either write fails so they never disagree.
+func saveCredentials(profile string, credentials *Credentials, state *SessionState) error {
+	paths, err := credentialPaths(profile)
 	if err != nil {
 		return err
 	}
-	tc.UpdateCredentials(generatedCredentials)
-	err = tc.Save()
+
+	snap, err := takeSnapshot(paths...)
 	if err != nil {
 		return err
 	}
 
-	err = limitedCreds.RecordExpiry(generatedCredentials.Expiry)
+	return rollbackOnError(snap, writeCredentials(snap, profile, credentials, state))
+}
+
+// the files saveCredentials writes for profile
+func credentialPaths(profile string) ([]string, error) {
+	tc, err := DefaultTemporaryCredentials(profile)
+	if err != nil {
+		return nil, err
+	}
+	statePath, err := sessionStatePath(profile)
+	if err != nil {
+		return nil, err
+	}
+	return []string{tc.path, statePath}, nil
+}
+
+// saves credentials as saveCredentials does, recording the writes in snap
+// for the caller to roll back
+func writeCredentials(snap *snapshot, profile string, credentials *Credentials, state *SessionState) error {
+	tc, err := DefaultTemporaryCredentials(profile)
 	if err != nil {
 		return err
 	}
 
-	fmt.Fprintf(os.Stderr, "Wrote credentials to %s\n", tc.path)
+	tc.UpdateCredentials(credentials)
+	written, err := tc.save()
+	if err != nil {
+		return err
+	}
+	snap.wrote(tc.path, written)
 
-	if cmd.OutputAsEnvVariable {
-		envVarExportsOutput(generatedCredentials)
+	state.Version = SessionStateVersion
+	state.Profile = profile
+	state.IssuedAt = Now().UTC()
+	state.Expiry = credentials.Expiry
+	state.SessionArn = credentials.SessionArn
+	state.AccessKeyFingerprint = accessKeyFingerprint(credentials.AccessKey)
+	written, err = state.save()
+	if err != nil {
+		return err
 	}
+	statePath, _ := sessionStatePath(profile)
+	snap.wrote(statePath, written)
+
+	fmt.Fprintf(os.Stderr, "Wrote credentials to %s\n", tc.path)
 
 	return nil
 }
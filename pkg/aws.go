@@ -3,21 +3,29 @@ package stscreds
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/organizations"
 	"github.com/aws/aws-sdk-go/service/sts"
 )
 
+// region of the STS endpoint used unless a profile configures another
+const DefaultRegion = "eu-west-1"
+
+// ensures the directory of the shared credentials file exists
 func ensureAwsDir() error {
-	awsDir, err := homePath(".aws")
+	path, err := awsCredentialsPath()
 	if err != nil {
 		return fmt.Errorf("Error finding home directory: %s", err)
 	}
+	awsDir := filepath.Dir(path)
 	if _, err = os.Stat(awsDir); os.IsNotExist(err) {
-		err := os.Mkdir(awsDir, 0755)
+		err := os.MkdirAll(awsDir, 0700)
 		if err != nil {
 			return fmt.Errorf("Error creating .aws directory: %s", err)
 		}
@@ -54,6 +62,110 @@ func currentUserName(sess *session.Session) (string, error) {
 	return *user.UserName, nil
 }
 
+// the documents of every policy that applies to the user: inline and
+// attached policies, on the user and on the groups they belong to.
+func userPolicyDocuments(sess *session.Session, username string) ([]string, error) {
+	svc := iam.New(sess)
+	var documents []string
+
+	var inline []string
+	err := svc.ListUserPoliciesPages(&iam.ListUserPoliciesInput{UserName: aws.String(username)}, func(page *iam.ListUserPoliciesOutput, lastPage bool) bool {
+		inline = append(inline, aws.StringValueSlice(page.PolicyNames)...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range inline {
+		resp, err := svc.GetUserPolicy(&iam.GetUserPolicyInput{UserName: aws.String(username), PolicyName: aws.String(name)})
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, *resp.PolicyDocument)
+	}
+
+	var attached []*iam.AttachedPolicy
+	err = svc.ListAttachedUserPoliciesPages(&iam.ListAttachedUserPoliciesInput{UserName: aws.String(username)}, func(page *iam.ListAttachedUserPoliciesOutput, lastPage bool) bool {
+		attached = append(attached, page.AttachedPolicies...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []*iam.Group
+	err = svc.ListGroupsForUserPages(&iam.ListGroupsForUserInput{UserName: aws.String(username)}, func(page *iam.ListGroupsForUserOutput, lastPage bool) bool {
+		groups = append(groups, page.Groups...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		var groupInline []string
+		err = svc.ListGroupPoliciesPages(&iam.ListGroupPoliciesInput{GroupName: group.GroupName}, func(page *iam.ListGroupPoliciesOutput, lastPage bool) bool {
+			groupInline = append(groupInline, aws.StringValueSlice(page.PolicyNames)...)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range groupInline {
+			resp, err := svc.GetGroupPolicy(&iam.GetGroupPolicyInput{GroupName: group.GroupName, PolicyName: aws.String(name)})
+			if err != nil {
+				return nil, err
+			}
+			documents = append(documents, *resp.PolicyDocument)
+		}
+
+		err = svc.ListAttachedGroupPoliciesPages(&iam.ListAttachedGroupPoliciesInput{GroupName: group.GroupName}, func(page *iam.ListAttachedGroupPoliciesOutput, lastPage bool) bool {
+			attached = append(attached, page.AttachedPolicies...)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, policy := range attached {
+		arn := *policy.PolicyArn
+		if seen[arn] {
+			continue
+		}
+		seen[arn] = true
+
+		p, err := svc.GetPolicy(&iam.GetPolicyInput{PolicyArn: aws.String(arn)})
+		if err != nil {
+			return nil, err
+		}
+		version, err := svc.GetPolicyVersion(&iam.GetPolicyVersionInput{PolicyArn: aws.String(arn), VersionId: p.Policy.DefaultVersionId})
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, *version.PolicyVersion.Document)
+	}
+
+	return documents, nil
+}
+
+// every role in the account
+func listRoles(sess *session.Session) ([]*iam.Role, error) {
+	svc := iam.New(sess)
+
+	var roles []*iam.Role
+	err := svc.ListRolesPages(&iam.ListRolesInput{}, func(page *iam.ListRolesOutput, lastPage bool) bool {
+		roles = append(roles, page.Roles...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
 func mfaSerialNumber(sess *session.Session, username string) (string, error) {
 	devices, err := mfaDevices(sess, username)
 	if err != nil {
@@ -67,29 +179,67 @@ func mfaSerialNumber(sess *session.Session, username string) (string, error) {
 	return *devices[0].SerialNumber, nil
 }
 
-func requestNewSTSToken(sess *session.Session, username, mfaToken string, expiry time.Duration, profile string) (*Credentials, error) {
-	serial, err := mfaSerialNumber(sess, username)
-	if err != nil {
-		return nil, err
-	}
-
+func requestNewSTSToken(sess *session.Session, serial, mfaToken string, expiry time.Duration) (*Credentials, error) {
 	input := &sts.GetSessionTokenInput{
 		DurationSeconds: aws.Int64(int64(expiry.Seconds())),
 		SerialNumber:    aws.String(serial),
 		TokenCode:       aws.String(mfaToken),
 	}
-	svc := sts.New(sess, &aws.Config{Region: aws.String("eu-west-1")})
+	svc := sts.New(sess, &aws.Config{Region: aws.String(DefaultRegion)})
 	out, err := svc.GetSessionToken(input)
 	if err != nil {
 		return nil, err
 	}
 
+	return newCredentials(out.Credentials), nil
+}
+
+// assumes a role through the STS endpoint in region, or the default region
+// when empty
+func assumeRole(sess *session.Session, region string, input *sts.AssumeRoleInput) (*Credentials, error) {
+	if region == "" {
+		region = DefaultRegion
+	}
+	svc := sts.New(sess, &aws.Config{Region: aws.String(region)})
+	out, err := svc.AssumeRole(input)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := newCredentials(out.Credentials)
+	if out.AssumedRoleUser != nil {
+		creds.SessionArn = aws.StringValue(out.AssumedRoleUser.Arn)
+	}
+	return creds, nil
+}
+
+// active accounts in the organization
+func organizationAccounts(sess *session.Session) ([]*organizations.Account, error) {
+	svc := organizations.New(sess)
+
+	var accounts []*organizations.Account
+	err := svc.ListAccountsPages(&organizations.ListAccountsInput{}, func(page *organizations.ListAccountsOutput, lastPage bool) bool {
+		for _, account := range page.Accounts {
+			if aws.StringValue(account.Status) == organizations.AccountStatusActive {
+				accounts = append(accounts, account)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+func newCredentials(c *sts.Credentials) *Credentials {
 	return &Credentials{
-		AccessKey:    *out.Credentials.AccessKeyId,
-		SecretKey:    *out.Credentials.SecretAccessKey,
-		SessionToken: *out.Credentials.SessionToken,
-		Expiry:       *out.Credentials.Expiration,
-	}, nil
+		AccessKey:    *c.AccessKeyId,
+		SecretKey:    *c.SecretAccessKey,
+		SessionToken: *c.SessionToken,
+		Expiry:       *c.Expiration,
+	}
 }
 
 type Credentials struct {
@@ -97,6 +247,14 @@ type Credentials struct {
 	SecretKey    string
 	SessionToken string
 	Expiry       time.Time
+
+	// the assumed role session, for credentials from a role
+	SessionArn string
+}
+
+func (c *Credentials) NewSession() *session.Session {
+	creds := credentials.NewStaticCredentials(c.AccessKey, c.SecretKey, c.SessionToken)
+	return newSession(&aws.Config{Credentials: creds})
 }
 
 func (c *Credentials) String() string {
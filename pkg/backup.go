@@ -0,0 +1,327 @@
+package stscreds
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the number of backups kept of each credentials file
+var BackupCount = 10
+
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// backups are kept in a directory per file, alongside a record of the path
+// they were taken from
+const backupOriginFile = "path"
+
+func backupsDir() (string, error) {
+	return stscredsPath("backups")
+}
+
+// the backup directory for a file, named after its path
+func backupDir(path string) (string, error) {
+	dir, err := backupsDir()
+	if err != nil {
+		return "", err
+	}
+
+	name := path
+	if home, err := homePath(); err == nil && strings.HasPrefix(path, home+string(filepath.Separator)) {
+		name = strings.TrimPrefix(path, home)
+	}
+	name = strings.Replace(strings.Trim(name, string(filepath.Separator)), string(filepath.Separator), "_", -1)
+
+	return filepath.Join(dir, name), nil
+}
+
+// copies path into its backup directory, if it exists, and removes all but
+// the newest BackupCount backups. Call it holding the file's lock.
+func backupFile(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	dir, err := backupDir(path)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(filepath.Join(dir, backupOriginFile), []byte(path+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+
+	name := time.Now().UTC().Format(backupTimeFormat)
+	err = writeFileAtomic(filepath.Join(dir, name), contents, 0600)
+	if err != nil {
+		return err
+	}
+
+	backups, err := backupTimes(dir)
+	if err != nil {
+		return err
+	}
+	for len(backups) > BackupCount {
+		os.Remove(filepath.Join(dir, backups[0].Format(backupTimeFormat)))
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+// the times of the backups in dir, oldest first
+func backupTimes(dir string) ([]time.Time, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var times []time.Time
+	for _, entry := range entries {
+		t, err := time.Parse(backupTimeFormat, entry.Name())
+		if err == nil {
+			times = append(times, t)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times, nil
+}
+
+// the backups of a file
+type fileBackups struct {
+	Path  string
+	dir   string
+	Times []time.Time
+}
+
+func listBackups() ([]*fileBackups, error) {
+	dir, err := backupsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var all []*fileBackups
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		fileDir := filepath.Join(dir, entry.Name())
+		origin, err := ioutil.ReadFile(filepath.Join(fileDir, backupOriginFile))
+		if err != nil {
+			continue
+		}
+		times, err := backupTimes(fileDir)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, &fileBackups{Path: strings.TrimSpace(string(origin)), dir: fileDir, Times: times})
+	}
+
+	return all, nil
+}
+
+// the contents of files before a change, and what each write in the change
+// left them holding, so the change can be rolled back if a later step
+// fails. Files changed since by another process are left as they are.
+type snapshot struct {
+	before  map[string][]byte
+	written map[string][]byte
+}
+
+// reads each file holding its lock, so a write in progress isn't seen half
+// done
+func takeSnapshot(paths ...string) (*snapshot, error) {
+	s := &snapshot{before: make(map[string][]byte), written: make(map[string][]byte)}
+	for _, path := range paths {
+		contents, err := readLocked(path)
+		if err != nil {
+			return nil, err
+		}
+		s.before[path] = contents
+	}
+	return s, nil
+}
+
+// the file's contents, or nil if it doesn't exist
+func readLocked(path string) ([]byte, error) {
+	unlock, err := lockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return contents, err
+}
+
+// records what a write to path left it holding
+func (s *snapshot) wrote(path string, contents []byte) {
+	s.written[path] = contents
+}
+
+// restores every file written since the snapshot was taken to its contents
+// then, removing those that didn't exist.
+func (s *snapshot) rollback() error {
+	var failed []string
+	for path, written := range s.written {
+		err := restoreFile(path, s.before[path], written)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", path, err.Error()))
+			continue
+		}
+		delete(s.written, path)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("error rolling back %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// restores path to before, unless it no longer holds what was written
+func restoreFile(path string, before, written []byte) error {
+	unlock, err := lockFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if !bytes.Equal(current, written) {
+		return fmt.Errorf("changed by another process since, so left as it is")
+	}
+
+	if before == nil {
+		err = os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return writeFileAtomic(path, before, 0600)
+}
+
+// rolls back the snapshot when err is set, returning err along with any
+// failure to roll back
+func rollbackOnError(s *snapshot, err error) error {
+	if err == nil {
+		return nil
+	}
+	if rollbackErr := s.rollback(); rollbackErr != nil {
+		return fmt.Errorf("%s (%s)", err.Error(), rollbackErr.Error())
+	}
+	return err
+}
+
+// lists backups of the credentials files or restores them as they were at a
+// point in time
+type RestoreCommand struct {
+	List bool
+	At   string
+}
+
+func (cmd *RestoreCommand) Execute() error {
+	all, err := listBackups()
+	if err != nil {
+		return err
+	}
+	if len(all) == 0 {
+		return fmt.Errorf("no backups found")
+	}
+
+	if cmd.List || cmd.At == "" {
+		for _, backups := range all {
+			fmt.Println(backups.Path)
+			for _, t := range backups.Times {
+				fmt.Printf("  %s\n", t.Format(time.RFC3339Nano))
+			}
+		}
+		return nil
+	}
+
+	at, err := parseRestoreTime(cmd.At)
+	if err != nil {
+		return err
+	}
+
+	for _, backups := range all {
+		// a backup holds the file as it was up until the change made when
+		// it was taken, so the file as it was at a time is the first backup
+		// taken from then on
+		var chosen *time.Time
+		for i := range backups.Times {
+			if !backups.Times[i].Before(at) {
+				chosen = &backups.Times[i]
+				break
+			}
+		}
+		if chosen == nil {
+			fmt.Fprintf(os.Stderr, "%s hasn't changed since %s\n", backups.Path, at.Format(time.RFC3339))
+			continue
+		}
+
+		err = backups.restore(*chosen)
+		if err != nil {
+			return fmt.Errorf("error restoring %s: %s", backups.Path, err.Error())
+		}
+		fmt.Fprintf(os.Stderr, "Restored %s from %s\n", backups.Path, chosen.Format(time.RFC3339Nano))
+	}
+
+	return nil
+}
+
+// restores the backup taken at t, first backing up the current file so the
+// restore can itself be undone
+func (b *fileBackups) restore(t time.Time) error {
+	contents, err := ioutil.ReadFile(filepath.Join(b.dir, t.Format(backupTimeFormat)))
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(b.Path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = backupFile(b.Path)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(b.Path, contents, 0600)
+}
+
+// accepts RFC3339 times, or times as listed by --list
+func parseRestoreTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, time.RFC3339, backupTimeFormat} {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected e.g. %s", value, time.Now().Format(time.RFC3339))
+}
@@ -0,0 +1,191 @@
+package stscreds
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writes contents to path the way stscreds does, backing up what was there
+func writeWithBackup(t *testing.T, path, contents string) {
+	if err := backupFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readString(path string) string {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+	return string(contents)
+}
+
+func TestBackupRotation(t *testing.T) {
+	dir, cleanup := testHome(t)
+	defer cleanup()
+	defer func(count int) { BackupCount = count }(BackupCount)
+	BackupCount = 3
+
+	path := filepath.Join(dir, ".aws", "credentials")
+	for _, contents := range []string{"1", "2", "3", "4", "5", "6"} {
+		writeWithBackup(t, path, contents)
+	}
+
+	all, err := listBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].Path != path {
+		t.Fatalf("got backups %+v, want one for %s", all, path)
+	}
+	if len(all[0].Times) != 3 {
+		t.Fatalf("kept %d backups, want 3", len(all[0].Times))
+	}
+
+	// the first write had nothing to back up, so the newest three of 1-5 remain
+	var kept []string
+	for _, at := range all[0].Times {
+		kept = append(kept, readString(filepath.Join(all[0].dir, at.Format(backupTimeFormat))))
+	}
+	if kept[0] != "3" || kept[1] != "4" || kept[2] != "5" {
+		t.Errorf("kept %q, want 3, 4 and 5", kept)
+	}
+}
+
+func TestBackupDir(t *testing.T) {
+	dir, cleanup := testHome(t)
+	defer cleanup()
+
+	tests := []struct {
+		path string
+		name string
+	}{
+		{filepath.Join(dir, ".aws", "credentials"), ".aws_credentials"},
+		{"/etc/aws/credentials", "etc_aws_credentials"},
+	}
+
+	for _, test := range tests {
+		backups, err := backupDir(test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(dir, ".stscreds", "backups", test.name); backups != want {
+			t.Errorf("backupDir(%s) = %s, want %s", test.path, backups, want)
+		}
+	}
+}
+
+func TestRestoreAt(t *testing.T) {
+	dir, cleanup := testHome(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, ".aws", "credentials")
+	writeWithBackup(t, path, "v1")
+	time.Sleep(10 * time.Millisecond)
+	beforeV2 := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	writeWithBackup(t, path, "v2")
+	time.Sleep(10 * time.Millisecond)
+	beforeV3 := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	writeWithBackup(t, path, "v3")
+	time.Sleep(10 * time.Millisecond)
+	afterV3 := time.Now()
+
+	tests := []struct {
+		at       time.Time
+		contents string
+	}{
+		{beforeV3, "v2"},
+		{beforeV2, "v1"},
+		{afterV3, "v3"},
+	}
+
+	for _, test := range tests {
+		cmd := &RestoreCommand{At: test.at.Format(time.RFC3339Nano)}
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		if contents := readString(path); contents != test.contents {
+			t.Errorf("restoring at %s gave %q, want %q", test.at, contents, test.contents)
+		}
+		// restores are backed up too, so later restores see them
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := parseRestoreTime("yesterday"); err == nil {
+		t.Error("expected an error parsing an invalid time")
+	}
+}
+
+func TestRollback(t *testing.T) {
+	dir, cleanup := testHome(t)
+	defer cleanup()
+	os.MkdirAll(dir+"/.aws", 0700)
+
+	existing := filepath.Join(dir, ".aws", "credentials")
+	created := filepath.Join(dir, ".aws", "created")
+	untouched := filepath.Join(dir, ".aws", "untouched")
+	ioutil.WriteFile(existing, []byte("before"), 0600)
+	ioutil.WriteFile(untouched, []byte("before"), 0600)
+
+	snap, err := takeSnapshot(existing, created, untouched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{existing, created} {
+		writeFileAtomic(path, []byte("written"), 0600)
+		snap.wrote(path, []byte("written"))
+	}
+	ioutil.WriteFile(untouched, []byte("changed elsewhere"), 0600)
+
+	if err := snap.rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if contents := readString(existing); contents != "before" {
+		t.Errorf("existing file rolled back to %q", contents)
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("created file wasn't removed: %v", err)
+	}
+	if contents := readString(untouched); contents != "changed elsewhere" {
+		t.Errorf("file that wasn't written was rolled back to %q", contents)
+	}
+
+	// rolling back again does nothing
+	if err := snap.rollback(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRollbackKeepsConcurrentWrites(t *testing.T) {
+	dir, cleanup := testHome(t)
+	defer cleanup()
+	os.MkdirAll(dir+"/.aws", 0700)
+
+	path := filepath.Join(dir, ".aws", "credentials")
+	ioutil.WriteFile(path, []byte("before"), 0600)
+
+	snap, err := takeSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFileAtomic(path, []byte("written"), 0600)
+	snap.wrote(path, []byte("written"))
+
+	// another process refreshes the credentials before the rollback
+	writeFileAtomic(path, []byte("fresh"), 0600)
+
+	if err := rollbackOnError(snap, os.ErrInvalid); err == nil || err.Error() == os.ErrInvalid.Error() {
+		t.Errorf("expected the skipped rollback to be reported, got %v", err)
+	}
+	if contents := readString(path); contents != "fresh" {
+		t.Errorf("rollback overwrote another process's write with %q", contents)
+	}
+}
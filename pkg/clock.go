@@ -0,0 +1,174 @@
+package stscreds
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/corehandlers"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// clocks further than this from AWS' are warned about; requests fail beyond
+// 5 minutes
+const clockSkewWarning = time.Minute
+
+// differences smaller than this are ignored; the Date header only has
+// second precision
+const clockSkewTolerance = 5 * time.Second
+
+var (
+	// how far AWS' clock is ahead of the local one, in nanoseconds
+	clockOffset int64
+
+	// held while a measured offset is compared with the last and saved, so
+	// concurrent responses don't each rewrite the file
+	setClockOffsetMu sync.Mutex
+
+	loadClockOffsetOnce sync.Once
+	warnClockSkewOnce   sync.Once
+)
+
+// the offset is kept between runs so expiry is compared correctly by
+// commands that don't call AWS
+func clockOffsetPath() (string, error) {
+	return stscredsPath("clock")
+}
+
+// how far AWS' clock is ahead of the local one, as measured from the Date
+// header of its responses
+func ClockOffset() time.Duration {
+	loadClockOffsetOnce.Do(loadClockOffset)
+	return time.Duration(atomic.LoadInt64(&clockOffset))
+}
+
+// the current time by AWS' clock
+func Now() time.Time {
+	return time.Now().Add(ClockOffset())
+}
+
+func loadClockOffset() {
+	path, err := clockOffsetPath()
+	if err != nil {
+		return
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	offset, err := time.ParseDuration(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return
+	}
+	atomic.StoreInt64(&clockOffset, int64(offset))
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// records a measured offset, saving it only when it differs from the last
+// by more than the tolerance. A wrong clock is warned about once in every
+// run, even when the offset is already known.
+func setClockOffset(offset time.Duration) {
+	if absDuration(offset) < clockSkewTolerance {
+		offset = 0
+	}
+
+	if absDuration(offset) > clockSkewWarning {
+		warnClockSkewOnce.Do(func() {
+			direction := "ahead of"
+			if offset > 0 {
+				direction = "behind"
+			}
+			fmt.Fprintf(os.Stderr, "warning: your clock is %s %s AWS', stscreds is correcting for it but turn on automatic time (NTP)\n", absDuration(offset).Round(time.Second), direction)
+		})
+	}
+
+	setClockOffsetMu.Lock()
+	defer setClockOffsetMu.Unlock()
+
+	if absDuration(offset-ClockOffset()) < clockSkewTolerance {
+		return
+	}
+	atomic.StoreInt64(&clockOffset, int64(offset))
+
+	err := saveClockOffset(offset)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: couldn't save clock offset: %s\n", err.Error())
+	}
+}
+
+func saveClockOffset(offset time.Duration) error {
+	path, err := clockOffsetPath()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if offset == 0 {
+		err = os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return writeFileAtomic(path, []byte(offset.String()+"\n"), 0600)
+}
+
+// measures the offset from the Date header of every response
+func recordServerTime(r *request.Request) {
+	if r.HTTPResponse == nil {
+		return
+	}
+	serverTime, err := http.ParseTime(r.HTTPResponse.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	setClockOffset(serverTime.Sub(time.Now()))
+}
+
+// re-signs requests with AWS' time just before they're sent. It replaces
+// the SDK's check for stale signatures, which would re-sign with the local
+// time, since service clients add their own signer after the session's.
+func signWithAWSTime(r *request.Request) {
+	if ClockOffset() == 0 {
+		corehandlers.ValidateReqSigHandler.Fn(r)
+		return
+	}
+	v4.SignSDKRequestWithCurrentTime(r, Now)
+}
+
+// retries a request that failed because of the clock, once, now that the
+// offset has been measured
+func retryAfterClockSkew(r *request.Request) {
+	if kind, _ := classifyAWSError(r.Error); kind == ErrClockSkew && r.RetryCount == 0 && ClockOffset() != 0 {
+		r.Retryable = aws.Bool(true)
+	}
+}
+
+// creates a session whose requests are signed with AWS' time, correcting
+// for the local clock
+func newSession(config *aws.Config) *session.Session {
+	sess := session.New(config)
+	sess.Handlers.Send.Swap(corehandlers.ValidateReqSigHandler.Name, request.NamedHandler{Name: corehandlers.ValidateReqSigHandler.Name, Fn: signWithAWSTime})
+	sess.Handlers.CompleteAttempt.PushBack(recordServerTime)
+	sess.Handlers.Retry.PushBack(retryAfterClockSkew)
+	return sess
+}
@@ -0,0 +1,115 @@
+package stscreds
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// forgets the measured offset, so the next use loads it from disk again
+func resetClockOffset() {
+	atomic.StoreInt64(&clockOffset, 0)
+	loadClockOffsetOnce = sync.Once{}
+}
+
+func TestSetClockOffset(t *testing.T) {
+	_, cleanup := testHome(t)
+	defer cleanup()
+	defer resetClockOffset()
+	resetClockOffset()
+
+	path, _ := clockOffsetPath()
+
+	tests := []struct {
+		measured time.Duration
+		offset   time.Duration
+		saved    string
+	}{
+		{3 * time.Second, 0, ""},
+		{-4 * time.Second, 0, ""},
+		{10 * time.Minute, 10 * time.Minute, "10m0s\n"},
+		// within the tolerance of the last, so neither changed nor saved
+		{10*time.Minute + 2*time.Second, 10 * time.Minute, "10m0s\n"},
+		{-time.Hour, -time.Hour, "-1h0m0s\n"},
+		// the clock was fixed, so the correction is removed
+		{time.Second, 0, ""},
+	}
+
+	for _, test := range tests {
+		setClockOffset(test.measured)
+		if offset := ClockOffset(); offset != test.offset {
+			t.Errorf("after measuring %s: offset %s, want %s", test.measured, offset, test.offset)
+		}
+
+		saved := readString(path)
+		if test.saved == "" {
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("after measuring %s: saved %q, want no file", test.measured, saved)
+			}
+		} else if saved != test.saved {
+			t.Errorf("after measuring %s: saved %q, want %q", test.measured, saved, test.saved)
+		}
+
+		// a new run loads the same offset
+		resetClockOffset()
+		if offset := ClockOffset(); offset != test.offset {
+			t.Errorf("after measuring %s: loaded %s, want %s", test.measured, offset, test.offset)
+		}
+	}
+}
+
+func TestSetClockOffsetConcurrently(t *testing.T) {
+	_, cleanup := testHome(t)
+	defer cleanup()
+	defer resetClockOffset()
+	resetClockOffset()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			setClockOffset(time.Hour + time.Duration(i)*100*time.Millisecond)
+		}(i)
+	}
+	wg.Wait()
+
+	if offset := ClockOffset(); absDuration(offset-time.Hour) >= 2*time.Second {
+		t.Errorf("offset %s, want about 1h", offset)
+	}
+}
+
+func TestSignWithAWSTime(t *testing.T) {
+	_, cleanup := testHome(t)
+	defer cleanup()
+	defer resetClockOffset()
+
+	tests := []time.Duration{0, time.Hour, -30 * time.Minute}
+
+	for _, offset := range tests {
+		resetClockOffset()
+		atomic.StoreInt64(&clockOffset, int64(offset))
+
+		sess := newSession(&aws.Config{Region: aws.String("eu-west-1"), Credentials: credentials.NewStaticCredentials("AKIA", "secret", "")})
+		req, _ := sts.New(sess).GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+		if err := req.Sign(); err != nil {
+			t.Fatal(err)
+		}
+
+		signWithAWSTime(req)
+
+		signed, err := time.Parse("20060102T150405Z", req.HTTPRequest.Header.Get("X-Amz-Date"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if skew := absDuration(signed.Sub(time.Now().Add(offset))); skew > 5*time.Second {
+			t.Errorf("with offset %s: signed at %s, %s from AWS' time", offset, signed, skew)
+		}
+	}
+}
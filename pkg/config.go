@@ -0,0 +1,407 @@
+package stscreds
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"gopkg.in/ini.v1"
+)
+
+// the session duration used when a role profile doesn't specify one; matches
+// the default maximum session duration of an IAM role.
+const DefaultRoleDuration = time.Hour
+
+// AWS managed policy applied as a session policy by --read-only
+const ReadOnlyPolicyArn = "arn:aws:iam::aws:policy/ReadOnlyAccess"
+
+// the source_identity of roles whose trust policy doesn't allow
+// sts:SetSourceIdentity, so none is sent
+const NoSourceIdentity = "none"
+
+// stscreds configuration, stored in ~/.stscreds/config. Each section
+// describes a profile whose credentials are requested by assuming a role.
+// Role profiles in the aws cli's ~/.aws/config are also honoured.
+type Config struct {
+	path          string
+	awsConfigPath string
+}
+
+func DefaultConfig() (*Config, error) {
+	path, err := stscredsPath("config")
+	if err != nil {
+		return nil, err
+	}
+	awsConfig, err := awsConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	return &Config{path: path, awsConfigPath: awsConfig}, nil
+}
+
+func (c *Config) file() (*ini.File, error) {
+	return loadConfigFile(c.path)
+}
+
+func (c *Config) awsConfigFile() (*ini.File, error) {
+	return loadConfigFile(c.awsConfigPath)
+}
+
+func loadConfigFile(path string) (*ini.File, error) {
+	cfg, err := ini.LooseLoad(path)
+	if err != nil {
+		return nil, errorf(ErrConfig, "error reading %s: %s", path, err.Error())
+	}
+	return cfg, nil
+}
+
+// profiles other than the default are written as [profile name] in
+// ~/.aws/config
+func awsConfigSection(profile string) string {
+	if profile == "default" {
+		return profile
+	}
+	return "profile " + profile
+}
+
+// the profile named by a ~/.aws/config section, or "" if it isn't a profile
+func awsConfigProfile(section string) string {
+	if section == "default" {
+		return section
+	}
+	if strings.HasPrefix(section, "profile ") {
+		return strings.TrimSpace(strings.TrimPrefix(section, "profile "))
+	}
+	return ""
+}
+
+// a profile whose credentials are requested by assuming RoleArn using the
+// temporary credentials of SourceProfile.
+type RoleProfile struct {
+	Name          string
+	RoleArn       string
+	SourceProfile string
+	Duration      time.Duration
+
+	// inline session policy (or file:// path) and managed policy arns used
+	// to restrict the role session
+	Policy     string
+	PolicyArns []string
+
+	// session tags, and the keys of those that persist through role
+	// chaining, attached to the role session
+	Tags              map[string]string
+	TransitiveTagKeys []string
+
+	// identifies the person assuming the role in CloudTrail; defaults to the
+	// IAM username when empty, and isn't sent when NoSourceIdentity
+	SourceIdentity string
+
+	// required by roles in third-party accounts
+	ExternalID string
+
+	// template for the role session name, see SessionNameContext
+	SessionName string
+
+	// the profile whose credentials are replaced by `elevate`
+	Elevates string
+
+	// the MFA device used to authenticate the source profile, looked up
+	// when empty
+	MFASerial string
+
+	// region of the STS endpoint the role is assumed through
+	Region string
+}
+
+// returns the role profile for name, or nil if name isn't a role profile.
+// Profiles in ~/.stscreds/config take precedence over those in ~/.aws/config.
+func (c *Config) RoleProfile(name string) (*RoleProfile, error) {
+	cfg, err := c.file()
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := roleProfile(cfg, name, name)
+	if err != nil || role != nil {
+		return role, err
+	}
+
+	awsConfig, err := c.awsConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	return roleProfile(awsConfig, awsConfigSection(name), name)
+}
+
+// every role profile, in the order they're configured
+func (c *Config) RoleProfiles() ([]*RoleProfile, error) {
+	roles, err := c.configRoleProfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, role := range roles {
+		seen[role.Name] = true
+	}
+
+	awsRoles, err := c.awsConfigRoleProfiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range awsRoles {
+		if !seen[role.Name] {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles, nil
+}
+
+// the role profiles configured in ~/.stscreds/config
+func (c *Config) configRoleProfiles() ([]*RoleProfile, error) {
+	cfg, err := c.file()
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []*RoleProfile
+	for _, sec := range cfg.Sections() {
+		if strings.HasPrefix(sec.Name(), groupSectionPrefix) {
+			continue
+		}
+		role, err := roleProfile(cfg, sec.Name(), sec.Name())
+		if err != nil {
+			return nil, err
+		}
+		if role != nil {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles, nil
+}
+
+// the role profiles configured in ~/.aws/config
+func (c *Config) awsConfigRoleProfiles() ([]*RoleProfile, error) {
+	awsConfig, err := c.awsConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []*RoleProfile
+	for _, sec := range awsConfig.Sections() {
+		name := awsConfigProfile(sec.Name())
+		if name == "" {
+			continue
+		}
+		role, err := roleProfile(awsConfig, sec.Name(), name)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles, nil
+}
+
+// the MFA device configured for a profile in either config file, or "" if
+// there isn't one.
+func (c *Config) MFASerial(profile string) (string, error) {
+	cfg, err := c.file()
+	if err != nil {
+		return "", err
+	}
+	if sec, err := cfg.GetSection(profile); err == nil && sec.HasKey("mfa_serial") {
+		return sec.Key("mfa_serial").String(), nil
+	}
+
+	awsConfig, err := c.awsConfigFile()
+	if err != nil {
+		return "", err
+	}
+	if sec, err := awsConfig.GetSection(awsConfigSection(profile)); err == nil {
+		return sec.Key("mfa_serial").String(), nil
+	}
+
+	return "", nil
+}
+
+// how long before a profile's credentials expire they're refreshed, from
+// refresh_before in ~/.stscreds/config, or 0 if it isn't set
+func (c *Config) RefreshBefore(profile string) (time.Duration, error) {
+	cfg, err := c.file()
+	if err != nil {
+		return 0, err
+	}
+	sec, err := cfg.GetSection(profile)
+	if err != nil || !sec.HasKey("refresh_before") {
+		return 0, nil
+	}
+	d, err := sec.Key("refresh_before").Duration()
+	if err != nil {
+		return 0, errorf(ErrConfig, "profile %s: invalid refresh_before: %s", profile, err.Error())
+	}
+	return d, nil
+}
+
+// groups are configured as [group name] sections listing their profiles
+const groupSectionPrefix = "group "
+
+// the role profiles in a group
+func (c *Config) Group(name string) ([]*RoleProfile, error) {
+	cfg, err := c.file()
+	if err != nil {
+		return nil, err
+	}
+
+	sec, err := cfg.GetSection(groupSectionPrefix + name)
+	if err != nil {
+		return nil, errorf(ErrConfig, "group %s not found in %s", name, c.path)
+	}
+
+	var roles []*RoleProfile
+	for _, profile := range sec.Key("profiles").Strings(",") {
+		role, err := c.RoleProfile(profile)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			return nil, errorf(ErrConfig, "profile %s in group %s isn't a role profile", profile, name)
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, nil
+}
+
+// reads the role profile name from section, returning nil if it isn't a role
+// profile.
+func roleProfile(cfg *ini.File, section, name string) (*RoleProfile, error) {
+	sec, err := cfg.GetSection(section)
+	if err != nil {
+		return nil, nil
+	}
+
+	if !sec.HasKey("role_arn") {
+		return nil, nil
+	}
+
+	role := &RoleProfile{
+		Name:           name,
+		RoleArn:        sec.Key("role_arn").String(),
+		SourceProfile:  sec.Key("source_profile").MustString("default"),
+		Duration:       sec.Key("duration").MustDuration(DefaultRoleDuration),
+		Policy:         sec.Key("policy").String(),
+		SourceIdentity: sec.Key("source_identity").String(),
+		ExternalID:     sec.Key("external_id").String(),
+		SessionName:    sec.Key("session_name").MustString(DefaultSessionNameTemplate),
+		Elevates:       sec.Key("elevates").MustString(name),
+		MFASerial:      sec.Key("mfa_serial").String(),
+		Region:         sec.Key("region").String(),
+	}
+
+	// the aws cli's name for the session duration
+	if sec.HasKey("duration_seconds") {
+		seconds, err := sec.Key("duration_seconds").Int()
+		if err != nil {
+			return nil, errorf(ErrConfig, "profile %s: invalid duration_seconds: %s", name, err.Error())
+		}
+		role.Duration = time.Duration(seconds) * time.Second
+	}
+
+	if sec.HasKey("policy_arns") {
+		role.PolicyArns = sec.Key("policy_arns").Strings(",")
+	}
+
+	role.Tags, err = parseTags(sec.Key("tags").Strings(","))
+	if err != nil {
+		return nil, errorf(ErrConfig, "profile %s: %s", name, err.Error())
+	}
+
+	if sec.HasKey("transitive_tag_keys") {
+		role.TransitiveTagKeys = sec.Key("transitive_tag_keys").Strings(",")
+	}
+	for _, key := range role.TransitiveTagKeys {
+		if _, ok := role.Tags[key]; !ok {
+			return nil, errorf(ErrConfig, "profile %s: transitive tag key %s isn't a tag", name, key)
+		}
+	}
+
+	if role.SourceProfile == name {
+		return nil, errorf(ErrConfig, "profile %s can't use itself as source_profile", name)
+	}
+
+	return role, nil
+}
+
+// parses tags written as key=value
+func parseTags(pairs []string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" {
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", pair)
+		}
+		tags[key] = strings.TrimSpace(parts[1])
+	}
+	return tags, nil
+}
+
+// session tags in key order
+func (r *RoleProfile) sessionTags() []*sts.Tag {
+	keys := make([]string, 0, len(r.Tags))
+	for key := range r.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tags := make([]*sts.Tag, 0, len(keys))
+	for _, key := range keys {
+		tags = append(tags, &sts.Tag{Key: aws.String(key), Value: aws.String(r.Tags[key])})
+	}
+	return tags
+}
+
+// reads a policy document, either inline or from a file:// path, and
+// checks it's valid JSON.
+func readPolicyDocument(policy string) (string, error) {
+	if strings.HasPrefix(policy, "file://") {
+		path := strings.TrimPrefix(policy, "file://")
+		if strings.HasPrefix(path, "~/") {
+			home, err := homePath()
+			if err != nil {
+				return "", err
+			}
+			path = home + path[1:]
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("error reading policy: %s", err.Error())
+		}
+		policy = string(contents)
+	}
+
+	var doc map[string]interface{}
+	err := json.Unmarshal([]byte(policy), &doc)
+	if err != nil {
+		return "", fmt.Errorf("invalid policy document: %s", err.Error())
+	}
+
+	return policy, nil
+}
+
+// the session state recorded for credentials from the role
+func (r *RoleProfile) sessionState() *SessionState {
+	return &SessionState{RoleArn: r.RoleArn, SourceProfile: r.SourceProfile, MFASerial: r.MFASerial}
+}
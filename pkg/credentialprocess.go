@@ -0,0 +1,63 @@
+package stscreds
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// prints a profile's credentials in the format the AWS SDKs and cli expect
+// from a credential_process
+type CredentialProcessCommand struct {
+	Profile string
+
+	// credentials with less than this left are refreshed
+	MinTTL time.Duration
+}
+
+type processCredentials struct {
+	Version         int
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      string
+}
+
+func (cmd *CredentialProcessCommand) Execute() error {
+	// credentials that have never been requested are treated as expired so
+	// they're requested now
+	state, err := LoadSessionState(cmd.Profile)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return ExpiredCredentialsErr(cmd.Profile)
+	}
+	err = checkLifetime(state, cmd.MinTTL, Now())
+	if err != nil {
+		return err
+	}
+
+	tc, err := DefaultTemporaryCredentials(cmd.Profile)
+	if err != nil {
+		return err
+	}
+	creds, err := tc.Load()
+	if err != nil {
+		return err
+	}
+	if creds == nil {
+		return ExpiredCredentialsErr(cmd.Profile)
+	}
+	if !state.Matches(creds) {
+		return OverwrittenCredentialsErr(cmd.Profile)
+	}
+
+	return json.NewEncoder(os.Stdout).Encode(&processCredentials{
+		Version:         1,
+		AccessKeyId:     creds.AccessKey,
+		SecretAccessKey: creds.SecretKey,
+		SessionToken:    creds.SessionToken,
+		Expiration:      state.Expiry.UTC().Format(time.RFC3339),
+	})
+}
@@ -13,15 +13,77 @@ import (
 	"gopkg.in/ini.v1"
 )
 
+// joins paths to the home directory, $HOME if it's set
 func homePath(paths ...string) (string, error) {
-	u, err := user.Current()
+	home := os.Getenv("HOME")
+	if home == "" {
+		u, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+		home = u.HomeDir
+	}
+	parts := append([]string{home}, paths...)
+	return filepath.Join(parts...), nil
+}
+
+// joins paths to the directory stscreds keeps its own files in:
+// $STSCREDS_HOME, otherwise ~/.stscreds if it exists, otherwise stscreds in
+// the XDG config directory if it exists or $XDG_CONFIG_HOME is set, and
+// ~/.stscreds failing that.
+func stscredsPath(paths ...string) (string, error) {
+	dir, err := stscredsDir()
 	if err != nil {
 		return "", err
 	}
-	parts := append([]string{u.HomeDir}, paths...)
+	parts := append([]string{dir}, paths...)
 	return filepath.Join(parts...), nil
 }
 
+func stscredsDir() (string, error) {
+	if dir := os.Getenv("STSCREDS_HOME"); dir != "" {
+		return dir, nil
+	}
+
+	legacy, err := homePath(".stscreds")
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy, nil
+	}
+
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "stscreds"), nil
+	}
+	xdg, err := homePath(".config", "stscreds")
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(xdg); err == nil {
+		return xdg, nil
+	}
+
+	return legacy, nil
+}
+
+// the shared credentials file stscreds writes temporary credentials to,
+// $AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials
+func awsCredentialsPath() (string, error) {
+	if path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); path != "" {
+		return path, nil
+	}
+	return homePath(".aws", "credentials")
+}
+
+// the aws cli's config file, $AWS_CONFIG_FILE or ~/.aws/config
+func awsConfigPath() (string, error) {
+	if path := os.Getenv("AWS_CONFIG_FILE"); path != "" {
+		return path, nil
+	}
+	return homePath(".aws", "config")
+}
+
 // credentials managed by stscreds that are requested through STS
 type TemporaryCredentials struct {
 	profile string
@@ -34,31 +96,36 @@ func (c *TemporaryCredentials) UpdateCredentials(credentials *Credentials) {
 	c.latestCredentials = credentials
 }
 
+// writes the credentials into the profile's section. Only the profile's
+// credential keys change; the rest of the file is left exactly as it was.
 func (c *TemporaryCredentials) Save() error {
-	cfg, err := ini.LooseLoad(c.path)
-	if err != nil {
-		return err
-	}
+	_, err := c.save()
+	return err
+}
 
-	sec, err := cfg.NewSection(c.profile)
+// saves the credentials, returning the file as written
+func (c *TemporaryCredentials) save() ([]byte, error) {
+	unlock, err := lockFile(c.path)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer unlock()
 
-	_, err = sec.NewKey("aws_access_key_id", c.latestCredentials.AccessKey)
+	editor, err := loadIniEditor(c.path)
 	if err != nil {
-		return err
-	}
-	_, err = sec.NewKey("aws_secret_access_key", c.latestCredentials.SecretKey)
-	if err != nil {
-		return err
+		return nil, err
 	}
-	_, err = sec.NewKey("aws_session_token", c.latestCredentials.SessionToken)
+
+	editor.Set(c.profile, "aws_access_key_id", c.latestCredentials.AccessKey)
+	editor.Set(c.profile, "aws_secret_access_key", c.latestCredentials.SecretKey)
+	editor.Set(c.profile, "aws_session_token", c.latestCredentials.SessionToken)
+
+	err = backupFile(c.path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return cfg.SaveTo(c.path)
+	return editor.Bytes(), writeFileAtomic(c.path, editor.Bytes(), 0600)
 }
 
 func (c *TemporaryCredentials) Read(key string) (interface{}, error) {
@@ -78,8 +145,60 @@ func (c *TemporaryCredentials) Read(key string) (interface{}, error) {
 	return sec.Key(key).String(), nil
 }
 
+// loads the credentials currently written for the profile, or nil if there
+// are none. The expiry isn't stored alongside them so is left unset.
+func (c *TemporaryCredentials) Load() (*Credentials, error) {
+	cfg, err := ini.LooseLoad(c.path)
+	if err != nil {
+		return nil, err
+	}
+	sec, err := cfg.GetSection(c.profile)
+	if err != nil || !sec.HasKey("aws_access_key_id") {
+		return nil, nil
+	}
+
+	return &Credentials{
+		AccessKey:    sec.Key("aws_access_key_id").String(),
+		SecretKey:    sec.Key("aws_secret_access_key").String(),
+		SessionToken: sec.Key("aws_session_token").String(),
+	}, nil
+}
+
+// removes the profile's credentials, and its section if nothing else is set
+func (c *TemporaryCredentials) Remove() error {
+	unlock, err := lockFile(c.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	editor, err := loadIniEditor(c.path)
+	if err != nil {
+		return err
+	}
+
+	editor.DeleteKey(c.profile, "aws_access_key_id")
+	editor.DeleteKey(c.profile, "aws_secret_access_key")
+	editor.DeleteKey(c.profile, "aws_session_token")
+	if len(editor.Keys(c.profile)) == 0 {
+		editor.DeleteSection(c.profile)
+	}
+
+	err = backupFile(c.path)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(c.path, editor.Bytes(), 0600)
+}
+
+func (c *TemporaryCredentials) NewSession() (*session.Session, error) {
+	creds := credentials.NewSharedCredentials(c.path, c.profile)
+	return newSession(&aws.Config{Credentials: creds}), nil
+}
+
 func DefaultTemporaryCredentials(profile string) (*TemporaryCredentials, error) {
-	path, err := homePath(".aws", "credentials")
+	path, err := awsCredentialsPath()
 	if err != nil {
 		return nil, err
 	}
@@ -93,53 +212,51 @@ type LimitedAccessCredentials struct {
 	profile string
 }
 
+// the key older versions recorded the expiry of temporary credentials under
+// in the long-term credentials file, now migrated to session state
 const ExpiresKey = "temp_credentials_expire"
 
-func (c *LimitedAccessCredentials) IsTemporaryCredentialsExpired(now time.Time) (bool, error) {
+func (c *LimitedAccessCredentials) legacyExpiry() (expires time.Time, ok bool, err error) {
 	cfg, err := c.file()
 	if err != nil {
-		return false, err
+		return time.Time{}, false, err
 	}
 
-	sec, err := cfg.NewSection(c.profile)
-	if err != nil {
-		return false, err
-	}
-
-	if !sec.HasKey(ExpiresKey) {
-		return false, nil
-	}
-
-	k, err := sec.GetKey(ExpiresKey)
-	if err != nil {
-		return false, err
+	sec, err := cfg.GetSection(c.profile)
+	if err != nil || !sec.HasKey(ExpiresKey) {
+		return time.Time{}, false, nil
 	}
 
-	expires, err := k.Time()
+	expires, err = sec.Key(ExpiresKey).Time()
 	if err != nil {
-		return false, err
+		return time.Time{}, false, err
 	}
 
-	return now.After(expires), nil
+	return expires, true, nil
 }
 
-func (c *LimitedAccessCredentials) RecordExpiry(expiresAt time.Time) error {
-	cfg, err := c.file()
+func (c *LimitedAccessCredentials) removeLegacyExpiry() error {
+	unlock, err := lockFile(c.path)
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	sec, err := cfg.NewSection(c.profile)
+	editor, err := loadIniEditor(c.path)
 	if err != nil {
 		return err
 	}
+	if _, ok := editor.Get(c.profile, ExpiresKey); !ok {
+		return nil
+	}
+	editor.DeleteKey(c.profile, ExpiresKey)
 
-	_, err = sec.NewKey(ExpiresKey, expiresAt.Format(time.RFC3339))
+	err = backupFile(c.path)
 	if err != nil {
 		return err
 	}
 
-	return cfg.SaveTo(c.path)
+	return writeFileAtomic(c.path, editor.Bytes(), 0600)
 }
 
 func (c *LimitedAccessCredentials) Exist() (bool, error) {
@@ -158,7 +275,7 @@ func (c *LimitedAccessCredentials) Exist() (bool, error) {
 }
 
 func DefaultLimitedAccessCredentials(profile string) (*LimitedAccessCredentials, error) {
-	filepath, err := homePath(".stscreds", "credentials")
+	filepath, err := stscredsPath("credentials")
 	if err != nil {
 		return nil, err
 	}
@@ -175,6 +292,12 @@ func (c *LimitedAccessCredentials) Initialise(keys *Keys) error {
 		return err
 	}
 
+	unlock, err := lockFile(c.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	cfg, err := c.file()
 	if err != nil {
 		return err
@@ -194,10 +317,15 @@ func (c *LimitedAccessCredentials) Initialise(keys *Keys) error {
 		return err
 	}
 
-	return cfg.SaveTo(c.path)
+	err = backupFile(c.path)
+	if err != nil {
+		return err
+	}
+
+	return saveIni(cfg, c.path)
 }
 
 func (c *LimitedAccessCredentials) NewSession() (*session.Session, error) {
 	stsCreds := credentials.NewSharedCredentials(c.path, c.profile)
-	return session.New(&aws.Config{Credentials: stsCreds}), nil
+	return newSession(&aws.Config{Credentials: stsCreds}), nil
 }
@@ -0,0 +1,89 @@
+package stscreds
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// explains an AWS error code and how to fix it
+type diagnosis struct {
+	code string
+
+	// matched against the lower-cased error message, when set
+	message string
+
+	hint string
+}
+
+// the most specific diagnoses come first
+var diagnoses = []diagnosis{
+	{
+		code:    "AccessDenied",
+		message: "iam:listmfadevices",
+		hint:    "Your IAM policy doesn't let you list your MFA devices. Set mfa_serial for the profile in ~/.stscreds/config, or ask an administrator to allow iam:ListMFADevices on your user as in the README's IAM Policy.",
+	},
+	{
+		code:    "AccessDenied",
+		message: "iam:getuser",
+		hint:    "Your IAM policy doesn't let you look up your own user. Ask an administrator to allow iam:GetUser on your user as in the README's IAM Policy.",
+	},
+	{
+		code:    "AccessDenied",
+		message: "sts:assumerole",
+		hint:    "You aren't allowed to assume the role. Check role_arn is right, and that the role's trust policy allows your user with MFA; `stscreds roles` lists the roles you can assume.",
+	},
+	{
+		code:    "AccessDenied",
+		message: "multifactorauthentication",
+		hint:    "Check the code comes from the MFA device set up for your IAM user (`stscreds whoami` lists it) and hasn't been used already. Codes also fail when your clock is wrong.",
+	},
+	{
+		code: "InvalidClientTokenId",
+		hint: "AWS doesn't recognise the access key. A long-term key in ~/.stscreds/credentials has been deleted, deactivated or mistyped: create a new access key for your IAM user and run `stscreds init`. If the key is a session's, run `stscreds auth`.",
+	},
+	{
+		code:    "SignatureDoesNotMatch",
+		message: "signature expired",
+		hint:    "Your clock is too far from AWS'. Turn on automatic time (NTP) and try again.",
+	},
+	{
+		code: "SignatureDoesNotMatch",
+		hint: "The secret access key doesn't belong to the access key; it was probably mistyped or cut short when pasted. Run `stscreds init` again, e.g. with --csv and the console's accessKeys.csv. If the keys are right check your clock.",
+	},
+	{
+		code: "ExpiredToken",
+		hint: "The session token has expired. Run `stscreds auth` for a new one; if it keeps happening check your clock.",
+	},
+	{
+		code: "RegionDisabledException",
+		hint: "STS isn't activated in the region used. Activate the region's STS endpoint in the IAM console under Account settings, or set the profile's region to one that's active.",
+	},
+	{
+		code: "RequestExpired",
+		hint: "Your clock is too far from AWS'. Turn on automatic time (NTP) and try again.",
+	},
+	{
+		code: "Throttling",
+		hint: "AWS is limiting how fast you make requests. Wait a little and try again, or lower --parallel.",
+	},
+	{
+		code: "RequestError",
+		hint: "AWS couldn't be reached. Check your network connection, proxy (HTTPS_PROXY) and DNS.",
+	},
+}
+
+// how to fix err, or "" if it's not a known AWS error
+func diagnose(err error) string {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return ""
+	}
+	message := strings.ToLower(aerr.Message())
+	for _, d := range diagnoses {
+		if d.code == aerr.Code() && strings.Contains(message, d.message) {
+			return d.hint
+		}
+	}
+	return ""
+}
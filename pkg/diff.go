@@ -0,0 +1,175 @@
+package stscreds
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// edits the ini file at path, prints the changes and, unless dryRun, saves
+// them. The file is locked while it's read, edited and written so changes
+// made meanwhile aren't lost.
+func editWithDiff(path string, dryRun bool, edit func(*iniEditor) error) error {
+	if !dryRun {
+		unlock, err := lockFile(path)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	before, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	editor := newIniEditor(before)
+	err = edit(editor)
+	if err != nil {
+		return err
+	}
+	after := editor.Bytes()
+
+	diff := unifiedDiff(path, before, after)
+	if diff == "" {
+		fmt.Fprintf(os.Stderr, "%s is up to date\n", path)
+		return nil
+	}
+	fmt.Print(diff)
+
+	if dryRun {
+		return nil
+	}
+
+	err = writeFileAtomic(path, after, 0600)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %s\n", path)
+	return nil
+}
+
+// lines of unchanged context shown around each change
+const diffContext = 3
+
+// a unified diff of the changes between before and after, or "" if they're
+// the same.
+func unifiedDiff(path string, before, after []byte) string {
+	if bytes.Equal(before, after) {
+		return ""
+	}
+
+	a := splitLines(before)
+	b := splitLines(after)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:], b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	type edit struct {
+		op   byte
+		line string
+		a, b int
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i, j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", path, path)
+
+	for start := 0; start < len(edits); {
+		if edits[start].op == ' ' {
+			start++
+			continue
+		}
+
+		// extend the hunk until there's more than twice the context of
+		// unchanged lines before the next change
+		from := start - diffContext
+		if from < 0 {
+			from = 0
+		}
+		end := start
+		for end < len(edits) {
+			if edits[end].op != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(edits) && edits[next].op == ' ' {
+				next++
+			}
+			if next == len(edits) || next-end > 2*diffContext {
+				break
+			}
+			end = next
+		}
+		to := end + diffContext
+		if to > len(edits) {
+			to = len(edits)
+		}
+
+		var aLines, bLines int
+		for _, e := range edits[from:to] {
+			if e.op != '+' {
+				aLines++
+			}
+			if e.op != '-' {
+				bLines++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(edits[from].a, aLines), hunkRange(edits[from].b, bLines))
+		for _, e := range edits[from:to] {
+			fmt.Fprintf(&out, "%c%s\n", e.op, e.line)
+		}
+
+		start = to
+	}
+
+	return out.String()
+}
+
+func hunkRange(start, lines int) string {
+	if lines == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, lines)
+}
+
+func splitLines(contents []byte) []string {
+	s := strings.TrimSuffix(string(contents), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
@@ -0,0 +1,81 @@
+package stscreds
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		diff   string
+	}{
+		{"unchanged", "a\nb\n", "a\nb\n", ""},
+		{"new file", "", "a\nb\n", "--- f\n+++ f\n@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"removed file", "a\n", "", "--- f\n+++ f\n@@ -1,1 +0,0 @@\n-a\n"},
+		{"changed line", "a\nb\nc\n", "a\nx\nc\n", "--- f\n+++ f\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"appended line", "a\nb\n", "a\nb\nc\n", "--- f\n+++ f\n@@ -1,2 +1,3 @@\n a\n b\n+c\n"},
+		{
+			"context is limited",
+			"1\n2\n3\n4\n5\n6\n7\n8\n",
+			"1\n2\n3\n4\nx\n6\n7\n8\n",
+			"--- f\n+++ f\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+x\n 6\n 7\n 8\n",
+		},
+		{
+			"nearby changes share a hunk",
+			"1\n2\n3\n4\n5\n6\n7\n",
+			"x\n2\n3\n4\n5\n6\ny\n",
+			"--- f\n+++ f\n@@ -1,7 +1,7 @@\n-1\n+x\n 2\n 3\n 4\n 5\n 6\n-7\n+y\n",
+		},
+		{
+			"distant changes get their own hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			"x\n2\n3\n4\n5\n6\n7\n8\n9\ny\n",
+			"--- f\n+++ f\n@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+y\n",
+		},
+	}
+
+	for _, test := range tests {
+		if diff := unifiedDiff("f", []byte(test.before), []byte(test.after)); diff != test.diff {
+			t.Errorf("%s: got %q, want %q", test.name, diff, test.diff)
+		}
+	}
+}
+
+func TestEditWithDiff(t *testing.T) {
+	dir, cleanup := testHome(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "config")
+	ioutil.WriteFile(path, []byte("[default]\na = 1\n"), 0600)
+
+	set := func(e *iniEditor) error {
+		e.Set("work", "a", "2")
+		return nil
+	}
+
+	if err := editWithDiff(path, true, set); err != nil {
+		t.Fatal(err)
+	}
+	if contents := readString(path); contents != "[default]\na = 1\n" {
+		t.Errorf("dry run wrote %q", contents)
+	}
+
+	// another process changes the file after it was first read
+	ioutil.WriteFile(path, []byte("[default]\na = 3\n"), 0600)
+
+	if err := editWithDiff(path, false, set); err != nil {
+		t.Fatal(err)
+	}
+	if contents := readString(path); contents != "[default]\na = 3\n\n[work]\na = 2\n" {
+		t.Errorf("got %q", contents)
+	}
+
+	if err := editWithDiff(filepath.Join(dir, "missing"), false, func(*iniEditor) error { return os.ErrInvalid }); err != os.ErrInvalid {
+		t.Errorf("edit error not returned: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package stscreds
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+var invalidProfileChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// generates a role profile, and matching ~/.aws/config profile, for every
+// account in the AWS Organization.
+type DiscoverAccountsCommand struct {
+	Auth          *AuthCommand
+	SourceProfile string
+
+	// role to assume in each account
+	RoleName string
+
+	// optional role, usually in the management account, that's allowed to
+	// list the organization's accounts
+	ManagementRoleArn string
+
+	ProfilePrefix string
+	Region        string
+	DryRun        bool
+
+	// overwrite the settings of profiles that already exist rather than
+	// skipping them
+	Force bool
+}
+
+func (cmd *DiscoverAccountsCommand) Execute() error {
+	base, err := cmd.Auth.baseSession(cmd.SourceProfile, "")
+	if err != nil {
+		return err
+	}
+
+	sess := base.session
+	if cmd.ManagementRoleArn != "" {
+		creds, err := assumeRole(sess, "", &sts.AssumeRoleInput{
+			RoleArn:         aws.String(cmd.ManagementRoleArn),
+			RoleSessionName: aws.String(base.username),
+		})
+		if err != nil {
+			return awsError(err, "error assuming role %s", cmd.ManagementRoleArn)
+		}
+		sess = creds.NewSession()
+	}
+
+	accounts, err := organizationAccounts(sess)
+	if err != nil {
+		return awsError(err, "error listing accounts")
+	}
+
+	config, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Found %d accounts\n", len(accounts))
+
+	profiles := cmd.profiles(accounts)
+	err = editWithDiff(config.path, cmd.DryRun, func(configFile *iniEditor) error {
+		for _, profile := range profiles {
+			if cmd.skip(configFile, profile.name, config.path) {
+				continue
+			}
+			configFile.Set(profile.name, "role_arn", fmt.Sprintf("arn:aws:iam::%s:role/%s", *profile.account.Id, cmd.RoleName))
+			configFile.Set(profile.name, "source_profile", cmd.SourceProfile)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if cmd.Region == "" {
+		return nil
+	}
+	return editWithDiff(config.awsConfigPath, cmd.DryRun, func(awsConfigFile *iniEditor) error {
+		for _, profile := range profiles {
+			section := awsConfigSection(profile.name)
+			if cmd.skip(awsConfigFile, section, config.awsConfigPath) {
+				continue
+			}
+			awsConfigFile.Set(section, "region", cmd.Region)
+		}
+		return nil
+	})
+}
+
+// whether to leave a section that already exists alone, reporting it
+func (cmd *DiscoverAccountsCommand) skip(file *iniEditor, section, path string) bool {
+	if cmd.Force || !file.HasSection(section) {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "Skipping %s, already in %s (use --force to overwrite it)\n", section, path)
+	return true
+}
+
+type accountProfile struct {
+	name    string
+	account *organizations.Account
+}
+
+// names a profile after each account, falling back to including the account
+// id when names collide.
+func (cmd *DiscoverAccountsCommand) profiles(accounts []*organizations.Account) []accountProfile {
+	var profiles []accountProfile
+	taken := make(map[string]bool)
+	for _, account := range accounts {
+		name := invalidProfileChars.ReplaceAllString(strings.ToLower(aws.StringValue(account.Name)), "-")
+		name = cmd.ProfilePrefix + strings.Trim(name, "-")
+		if taken[name] || name == cmd.ProfilePrefix {
+			name = fmt.Sprintf("%s-%s", name, *account.Id)
+		}
+		taken[name] = true
+		profiles = append(profiles, accountProfile{name: name, account: account})
+	}
+	return profiles
+}
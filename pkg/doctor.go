@@ -0,0 +1,382 @@
+package stscreds
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"gopkg.in/ini.v1"
+)
+
+// checks the environment, files and AWS setup stscreds relies on, printing
+// what's wrong and how to fix it
+type DoctorCommand struct {
+	Profile string
+}
+
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+type checkResult struct {
+	status string
+	name   string
+	detail string
+	fix    string
+}
+
+func pass(name, format string, args ...interface{}) *checkResult {
+	return &checkResult{status: checkPass, name: name, detail: fmt.Sprintf(format, args...)}
+}
+
+func warn(name, fix, format string, args ...interface{}) *checkResult {
+	return &checkResult{status: checkWarn, name: name, detail: fmt.Sprintf(format, args...), fix: fix}
+}
+
+func fail(name, fix, format string, args ...interface{}) *checkResult {
+	return &checkResult{status: checkFail, name: name, detail: fmt.Sprintf(format, args...), fix: fix}
+}
+
+func (cmd *DoctorCommand) Execute() error {
+	var results []*checkResult
+	results = append(results, checkEnvironment()...)
+	results = append(results, checkFiles()...)
+	results = append(results, cmd.checkProfile()...)
+	results = append(results, cmd.checkAWS()...)
+
+	failed := 0
+	for _, r := range results {
+		fmt.Printf("[%s] %s: %s\n", r.status, r.name, r.detail)
+		if r.fix != "" {
+			fmt.Printf("       fix: %s\n", r.fix)
+		}
+		if r.status == checkFail {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return errorf(ErrChecksFailed, "%d of %d checks failed", failed, len(results))
+	}
+	return nil
+}
+
+// environment variables that change which profile, files or region are
+// used; their values are shown
+var configEnvironment = []string{"AWS_PROFILE", "AWS_DEFAULT_PROFILE", "AWS_SHARED_CREDENTIALS_FILE", "AWS_CONFIG_FILE", "AWS_REGION", "AWS_DEFAULT_REGION", "STSCREDS_HOME"}
+
+// checks for credentials set in the environment, and lists the other AWS
+// variables that change what's used
+func checkEnvironment() []*checkResult {
+	var results []*checkResult
+
+	set := overridingEnvironment()
+	if len(set) > 0 {
+		results = append(results, warn("environment", "remove them from your environment; check ~/.bash_profile etc.", "%s set, overriding ~/.aws/credentials", strings.Join(set, ", ")))
+	} else {
+		results = append(results, pass("environment", "no AWS credentials set in the environment"))
+	}
+
+	var overrides []string
+	for _, name := range configEnvironment {
+		if value := os.Getenv(name); value != "" {
+			overrides = append(overrides, name+"="+value)
+		}
+	}
+	if len(overrides) > 0 {
+		results = append(results, warn("environment", "unset them unless that's what you want", "%s set, overriding the profile, files or region stscreds would use", strings.Join(overrides, ", ")))
+	}
+
+	// values of other variables aren't shown in case they're secret
+	var others []string
+	known := map[string]bool{}
+	for _, name := range append(configEnvironment, credentialEnvironment...) {
+		known[name] = true
+	}
+	for _, env := range os.Environ() {
+		name := strings.SplitN(env, "=", 2)[0]
+		if strings.HasPrefix(name, "AWS_") && !known[name] && os.Getenv(name) != "" {
+			others = append(others, name)
+		}
+	}
+	if len(others) > 0 {
+		sort.Strings(others)
+		results = append(results, pass("environment", "%s set", strings.Join(others, ", ")))
+	}
+
+	return results
+}
+
+// checks each file exists where needed, parses, and only its owner can
+// read it
+func checkFiles() []*checkResult {
+	stscredsCredentials, _ := stscredsPath("credentials")
+	stscredsConfig, _ := stscredsPath("config")
+	awsCredentials, _ := awsCredentialsPath()
+	awsConfig, _ := awsConfigPath()
+
+	files := []struct {
+		path    string
+		missing *checkResult
+	}{
+		{stscredsCredentials, fail(stscredsCredentials, "run stscreds init", "doesn't exist")},
+		{awsCredentials, warn(awsCredentials, "run stscreds auth", "doesn't exist")},
+		{stscredsConfig, nil},
+		{awsConfig, nil},
+	}
+
+	var results []*checkResult
+	for _, f := range files {
+		fi, err := os.Stat(f.path)
+		if os.IsNotExist(err) {
+			if f.missing != nil {
+				results = append(results, f.missing)
+			}
+			continue
+		}
+		if err != nil {
+			results = append(results, fail(f.path, "", "%s", err.Error()))
+			continue
+		}
+
+		if mode := fi.Mode().Perm(); mode&0077 != 0 {
+			results = append(results, fail(f.path, "chmod 600 "+f.path, "accessible to other users (mode %04o)", mode))
+			continue
+		}
+		if stat, ok := fi.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+			results = append(results, warn(f.path, "chown it to yourself", "owned by uid %d, not the current user", stat.Uid))
+			continue
+		}
+
+		_, err = ini.Load(f.path)
+		if err != nil {
+			results = append(results, fail(f.path, "correct the syntax error", "%s", err.Error()))
+			continue
+		}
+
+		results = append(results, pass(f.path, "readable only by you"))
+	}
+
+	return results
+}
+
+// checks the profile is set up in both credentials files, and that they
+// agree
+func (cmd *DoctorCommand) checkProfile() []*checkResult {
+	var results []*checkResult
+
+	keysProfile, err := cmd.keysProfile()
+	if err != nil {
+		return []*checkResult{fail("profile", "", "%s", err.Error())}
+	}
+	limitedCreds, err := DefaultLimitedAccessCredentials(keysProfile)
+	if err != nil {
+		return []*checkResult{fail("profile", "", "%s", err.Error())}
+	}
+	cfg, err := ini.LooseLoad(limitedCreds.path)
+	if err != nil {
+		return nil
+	}
+	if sec, err := cfg.GetSection(keysProfile); err != nil || !sec.HasKey("aws_access_key_id") {
+		results = append(results, fail("profile", fmt.Sprintf("run stscreds init --profile=%s", keysProfile), "%s has no long-term keys in %s", keysProfile, limitedCreds.path))
+	} else {
+		results = append(results, pass("profile", "%s has long-term keys in %s", keysProfile, limitedCreds.path))
+	}
+
+	tc, err := DefaultTemporaryCredentials(cmd.Profile)
+	if err != nil {
+		return results
+	}
+	current, err := tc.Load()
+	if err != nil {
+		return results
+	}
+	if current != nil && current.SessionToken == "" && keysProfile == cmd.Profile {
+		results = append(results, warn("session", fmt.Sprintf("run stscreds init --migrate --profile=%s", cmd.Profile), "%s in %s holds long-term keys, not a session", cmd.Profile, tc.path))
+		return results
+	}
+
+	state, err := LoadSessionState(cmd.Profile)
+	if err != nil {
+		return append(results, fail("session", "", "%s", err.Error()))
+	}
+	switch {
+	case state == nil || current == nil:
+		results = append(results, warn("session", "run stscreds auth", "%s hasn't been authenticated", cmd.Profile))
+	case !state.Matches(current):
+		results = append(results, warn("session", "run stscreds auth to replace them", "%s in %s was changed outside stscreds", cmd.Profile, tc.path))
+	case !state.Valid(Now()):
+		results = append(results, warn("session", "run stscreds auth", "expired at %s", state.Expiry.Local().Format(time.RFC3339)))
+	default:
+		results = append(results, pass("session", "valid until %s", state.Expiry.Local().Format(time.RFC3339)))
+	}
+
+	return results
+}
+
+// checks the long-term keys, MFA device, clock, region and endpoints
+func (cmd *DoctorCommand) checkAWS() []*checkResult {
+	keysProfile, err := cmd.keysProfile()
+	if err != nil {
+		return nil
+	}
+	limitedCreds, err := DefaultLimitedAccessCredentials(keysProfile)
+	if err != nil {
+		return nil
+	}
+	if exist, err := limitedCreds.Exist(); err != nil || !exist {
+		return nil
+	}
+	sess, err := limitedCreds.NewSession()
+	if err != nil {
+		return []*checkResult{fail("aws", "", "%s", err.Error())}
+	}
+
+	var results []*checkResult
+
+	regions := []string{DefaultRegion}
+	if region := cmd.roleRegion(); region != "" && region != DefaultRegion {
+		regions = append(regions, region)
+	}
+
+	var serverTime time.Time
+	for _, region := range regions {
+		t, err := stsServerTime(sess, region)
+		if t.IsZero() && err != nil {
+			results = append(results, fail("sts endpoint", diagnose(err), "couldn't reach STS in %s: %s", region, err.Error()))
+		} else {
+			results = append(results, pass("sts endpoint", "reachable in %s", region))
+		}
+		if serverTime.IsZero() {
+			serverTime = t
+		}
+	}
+
+	if serverTime.IsZero() {
+		results = append(results, warn("clock", "", "STS didn't send the time, so the clock couldn't be checked"))
+	} else {
+		skew := time.Since(serverTime)
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > clockSkewWarning {
+			results = append(results, fail("clock", "turn on automatic time (NTP)", "%s from AWS' clock", skew.Round(time.Second)))
+		} else {
+			results = append(results, pass("clock", "within %s of AWS' clock", clockSkewWarning))
+		}
+	}
+
+	user, err := getUser(sess)
+	if err != nil {
+		results = append(results, fail("long-term key", diagnose(err), "%s", err.Error()))
+		return results
+	}
+	results = append(results, pass("long-term key", "valid for %s", aws.StringValue(user.UserName)))
+
+	results = append(results, cmd.checkMFA(sess, keysProfile, aws.StringValue(user.UserName)))
+	results = append(results, cmd.checkRegion())
+
+	return results
+}
+
+// the profile whose long-term keys are used: the source profile of a role
+// profile, otherwise the profile itself
+func (cmd *DoctorCommand) keysProfile() (string, error) {
+	config, err := DefaultConfig()
+	if err != nil {
+		return "", err
+	}
+	role, err := config.RoleProfile(cmd.Profile)
+	if err != nil {
+		return "", err
+	}
+	if role != nil {
+		return role.SourceProfile, nil
+	}
+	return cmd.Profile, nil
+}
+
+// the region of the profile when it's a role profile that sets one
+func (cmd *DoctorCommand) roleRegion() string {
+	config, err := DefaultConfig()
+	if err != nil {
+		return ""
+	}
+	role, err := config.RoleProfile(cmd.Profile)
+	if err != nil || role == nil {
+		return ""
+	}
+	return role.Region
+}
+
+func (cmd *DoctorCommand) checkMFA(sess *session.Session, profile, username string) *checkResult {
+	config, err := DefaultConfig()
+	if err != nil {
+		return fail("mfa device", "", "%s", err.Error())
+	}
+	serial, err := config.MFASerial(profile)
+	if err != nil {
+		return fail("mfa device", "", "%s", err.Error())
+	}
+	if serial != "" {
+		return pass("mfa device", "%s set by mfa_serial", serial)
+	}
+
+	devices, err := mfaDevices(sess, username)
+	if err != nil {
+		return fail("mfa device", diagnose(err), "%s", err.Error())
+	}
+	switch len(devices) {
+	case 0:
+		return fail("mfa device", "assign a virtual MFA device to your user in the IAM console", "%s has no MFA device", username)
+	case 1:
+		return pass("mfa device", "%s", aws.StringValue(devices[0].SerialNumber))
+	}
+	return fail("mfa device", "choose one with mfa_serial in ~/.stscreds/config", "%s has %d MFA devices", username, len(devices))
+}
+
+func (cmd *DoctorCommand) checkRegion() *checkResult {
+	if os.Getenv("AWS_REGION") != "" || os.Getenv("AWS_DEFAULT_REGION") != "" {
+		return pass("region", "set in the environment")
+	}
+
+	path, err := awsConfigPath()
+	if err != nil {
+		return fail("region", "", "%s", err.Error())
+	}
+	cfg, err := ini.LooseLoad(path)
+	if err != nil {
+		return fail("region", "", "%s", err.Error())
+	}
+	sec, err := cfg.GetSection(awsConfigSection(cmd.Profile))
+	if err != nil || sec.Key("region").String() == "" {
+		return warn("region", fmt.Sprintf("aws configure set region %s --profile %s", DefaultRegion, cmd.Profile), "no region set for %s, so tools and SDKs need one passed", cmd.Profile)
+	}
+	return pass("region", "%s", sec.Key("region").String())
+}
+
+// AWS' time from the Date header of an STS response. The time is returned
+// whenever there was a response, even if the request failed, since a wrong
+// clock causes requests to fail.
+func stsServerTime(sess *session.Session, region string) (time.Time, error) {
+	svc := sts.New(sess, &aws.Config{Region: aws.String(region)})
+	req, _ := svc.GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+	err := req.Send()
+	if req.HTTPResponse == nil {
+		return time.Time{}, err
+	}
+	t, parseErr := http.ParseTime(req.HTTPResponse.Header.Get("Date"))
+	if parseErr != nil {
+		return time.Time{}, err
+	}
+	return t, err
+}
@@ -0,0 +1,63 @@
+package stscreds
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheckEnvironment(t *testing.T) {
+	saved := os.Environ()
+	defer func() {
+		os.Clearenv()
+		for _, env := range saved {
+			kv := strings.SplitN(env, "=", 2)
+			os.Setenv(kv[0], kv[1])
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		env     []string
+		results []string
+	}{
+		{"nothing set", nil, []string{"pass: no AWS credentials set in the environment"}},
+		{
+			"credentials",
+			[]string{"AWS_ACCESS_KEY_ID=AKIA", "AWS_SECRET_ACCESS_KEY=secret"},
+			[]string{"warn: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY set, overriding ~/.aws/credentials"},
+		},
+		{
+			"overriding profile and region",
+			[]string{"AWS_PROFILE=work", "AWS_REGION=us-east-1"},
+			[]string{
+				"pass: no AWS credentials set in the environment",
+				"warn: AWS_PROFILE=work, AWS_REGION=us-east-1 set, overriding the profile, files or region stscreds would use",
+			},
+		},
+		{
+			"other variables hide their values",
+			[]string{"AWS_SDK_LOAD_CONFIG=1", "AWS_CA_BUNDLE=/etc/ca.pem"},
+			[]string{
+				"pass: no AWS credentials set in the environment",
+				"pass: AWS_CA_BUNDLE, AWS_SDK_LOAD_CONFIG set",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		os.Clearenv()
+		for _, env := range test.env {
+			kv := strings.SplitN(env, "=", 2)
+			os.Setenv(kv[0], kv[1])
+		}
+
+		var results []string
+		for _, r := range checkEnvironment() {
+			results = append(results, r.status+": "+r.detail)
+		}
+		if strings.Join(results, "\n") != strings.Join(test.results, "\n") {
+			t.Errorf("%s: got %q, want %q", test.name, results, test.results)
+		}
+	}
+}
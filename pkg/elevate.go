@@ -0,0 +1,456 @@
+package stscreds
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"regexp"
+	"syscall"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// the longest elevated credentials can be requested for
+const MaxElevationDuration = time.Hour
+
+// the shortest session STS issues for a role
+const MinElevationDuration = 15 * time.Minute
+
+// session tag carrying the justification for elevated access
+const ElevationReasonTag = "elevation-reason"
+
+// characters allowed in session tag values
+var validReason = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]{1,256}$`)
+
+// requests short-lived credentials for a privileged role, replacing the
+// credentials of the profile it elevates until they expire.
+type ElevateCommand struct {
+	Auth     *AuthCommand
+	Profile  string
+	Reason   string
+	Duration time.Duration
+}
+
+func (cmd *ElevateCommand) Execute() error {
+	if !validReason.MatchString(cmd.Reason) {
+		return fmt.Errorf("reason must be 1-256 letters, numbers, spaces or _.:/=+-@")
+	}
+
+	err := ensureAwsDir()
+	if err != nil {
+		return fmt.Errorf("Error ensuring .aws directory: %s", err)
+	}
+
+	config, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+
+	role, err := config.RoleProfile(cmd.Profile)
+	if err != nil {
+		return err
+	}
+	if role == nil {
+		return fmt.Errorf("profile %s isn't a role profile", cmd.Profile)
+	}
+
+	duration := cmd.Duration
+	if duration > MaxElevationDuration {
+		fmt.Fprintf(os.Stderr, "warning: elevated access is limited to %s\n", MaxElevationDuration)
+		duration = MaxElevationDuration
+	}
+	if duration < MinElevationDuration {
+		fmt.Fprintf(os.Stderr, "warning: elevated access lasts at least %s\n", MinElevationDuration)
+		duration = MinElevationDuration
+	}
+	role.Duration = duration
+
+	tags := map[string]string{ElevationReasonTag: cmd.Reason}
+	for key, value := range role.Tags {
+		tags[key] = value
+	}
+	role.Tags = tags
+
+	generatedCredentials, err := cmd.Auth.assumeRole(role)
+	if err != nil {
+		return err
+	}
+
+	elevations, err := DefaultElevations()
+	if err != nil {
+		return err
+	}
+
+	paths, err := credentialPaths(role.Elevates)
+	if err != nil {
+		return err
+	}
+
+	// without an audit record the elevation is undone
+	snap, err := takeSnapshot(append(paths, elevations.path)...)
+	if err != nil {
+		return err
+	}
+
+	written, err := elevations.stash(role.Elevates, generatedCredentials.Expiry)
+	if err != nil {
+		return rollbackOnError(snap, fmt.Errorf("error saving current credentials: %s", err.Error()))
+	}
+	snap.wrote(elevations.path, written)
+
+	err = writeCredentials(snap, role.Elevates, generatedCredentials, role.sessionState())
+	if err != nil {
+		return rollbackOnError(snap, err)
+	}
+
+	expires := generatedCredentials.Expiry.UTC()
+	err = appendAuditLog(&AuditEvent{
+		Time:    time.Now().UTC(),
+		Event:   "elevate",
+		User:    localUsername(),
+		Profile: role.Elevates,
+		RoleArn: role.RoleArn,
+		Reason:  cmd.Reason,
+		Expires: &expires,
+	})
+	if err != nil {
+		return rollbackOnError(snap, fmt.Errorf("error writing audit log: %s", err.Error()))
+	}
+
+	fmt.Fprintf(os.Stderr, "Elevated %s until %s\n", role.Elevates, generatedCredentials.Expiry.Local().Format(time.Kitchen))
+
+	err = scheduleRestore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: couldn't schedule restoring %s's previous credentials, they'll be restored the next time stscreds runs: %s\n", role.Elevates, err.Error())
+	} else {
+		fmt.Fprintf(os.Stderr, "Previous credentials will be restored then, or the next time stscreds runs if this machine is off\n")
+	}
+
+	if cmd.Auth.OutputAsEnvVariable {
+		envVarExportsOutput(generatedCredentials)
+	}
+
+	return nil
+}
+
+// the credentials that were replaced by elevated credentials, stored in
+// ~/.stscreds/elevations so they can be restored once the elevation ends.
+type Elevations struct {
+	path string
+}
+
+const elevatedUntilKey = "elevated_until"
+
+// the stashed session state, as base64 encoded json
+const sessionStateKey = "session_state"
+
+func DefaultElevations() (*Elevations, error) {
+	path, err := stscredsPath("elevations")
+	if err != nil {
+		return nil, err
+	}
+	return &Elevations{path: path}, nil
+}
+
+// stashes the profile's current credentials. When the profile is already
+// elevated the original credentials are kept and only the end is extended.
+func (e *Elevations) Stash(profile string, until time.Time) error {
+	_, err := e.stash(profile, until)
+	return err
+}
+
+// stashes the credentials, returning the file as written
+func (e *Elevations) stash(profile string, until time.Time) ([]byte, error) {
+	unlock, err := lockFile(e.path)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	cfg, err := ini.LooseLoad(e.path)
+	if err != nil {
+		return nil, err
+	}
+
+	sec, err := cfg.GetSection(profile)
+	if err == nil {
+		sec.Key(elevatedUntilKey).SetValue(until.Format(time.RFC3339))
+		return writeIni(cfg, e.path)
+	}
+
+	sec, err = cfg.NewSection(profile)
+	if err != nil {
+		return nil, err
+	}
+	sec.Key(elevatedUntilKey).SetValue(until.Format(time.RFC3339))
+
+	tc, err := DefaultTemporaryCredentials(profile)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := tc.Load()
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		sec.Key("aws_access_key_id").SetValue(previous.AccessKey)
+		sec.Key("aws_secret_access_key").SetValue(previous.SecretKey)
+		sec.Key("aws_session_token").SetValue(previous.SessionToken)
+	}
+
+	state, err := LoadSessionState(profile)
+	if err != nil {
+		return nil, err
+	}
+	if state != nil {
+		encoded, err := json.Marshal(state)
+		if err != nil {
+			return nil, err
+		}
+		sec.Key(sessionStateKey).SetValue(base64.StdEncoding.EncodeToString(encoded))
+	}
+
+	return writeIni(cfg, e.path)
+}
+
+// when the first elevation ends; ok is false when nothing is elevated
+func (e *Elevations) NextEnd() (next time.Time, ok bool, err error) {
+	cfg, err := ini.LooseLoad(e.path)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	for _, sec := range cfg.Sections() {
+		if !sec.HasKey(elevatedUntilKey) {
+			continue
+		}
+		until, err := sec.Key(elevatedUntilKey).Time()
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if !ok || until.Before(next) {
+			next, ok = until, true
+		}
+	}
+	return next, ok, nil
+}
+
+// restores the credentials of every profile whose elevation has ended
+func (e *Elevations) RestoreExpired(now time.Time) error {
+	unlock, err := lockFile(e.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cfg, err := ini.LooseLoad(e.path)
+	if err != nil {
+		return err
+	}
+
+	restored := false
+	for _, sec := range cfg.Sections() {
+		if !sec.HasKey(elevatedUntilKey) {
+			continue
+		}
+		until, err := sec.Key(elevatedUntilKey).Time()
+		if err != nil {
+			return err
+		}
+		if now.Before(until) {
+			continue
+		}
+
+		err = restore(sec)
+		if err != nil {
+			return fmt.Errorf("error restoring credentials for %s: %s", sec.Name(), err.Error())
+		}
+
+		err = appendAuditLog(&AuditEvent{
+			Time:    now.UTC(),
+			Event:   "restore",
+			User:    localUsername(),
+			Profile: sec.Name(),
+		})
+		if err != nil {
+			return fmt.Errorf("error writing audit log: %s", err.Error())
+		}
+
+		fmt.Fprintf(os.Stderr, "Elevated access to %s ended, restored previous credentials\n", sec.Name())
+		cfg.DeleteSection(sec.Name())
+		restored = true
+	}
+
+	if !restored {
+		return nil
+	}
+	return saveIni(cfg, e.path)
+}
+
+func restore(sec *ini.Section) error {
+	profile := sec.Name()
+
+	tc, err := DefaultTemporaryCredentials(profile)
+	if err != nil {
+		return err
+	}
+	if !sec.HasKey("aws_access_key_id") {
+		err = tc.Remove()
+		if err != nil {
+			return err
+		}
+		return RemoveSessionState(profile)
+	}
+
+	tc.UpdateCredentials(&Credentials{
+		AccessKey:    sec.Key("aws_access_key_id").String(),
+		SecretKey:    sec.Key("aws_secret_access_key").String(),
+		SessionToken: sec.Key("aws_session_token").String(),
+	})
+	err = tc.Save()
+	if err != nil {
+		return err
+	}
+
+	state, err := stashedSessionState(sec)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return RemoveSessionState(profile)
+	}
+	return state.Save()
+}
+
+// the session state stashed with the credentials; elevations stashed by
+// older versions only recorded the expiry
+func stashedSessionState(sec *ini.Section) (*SessionState, error) {
+	if sec.HasKey(sessionStateKey) {
+		encoded, err := base64.StdEncoding.DecodeString(sec.Key(sessionStateKey).String())
+		if err != nil {
+			return nil, err
+		}
+		var state SessionState
+		err = json.Unmarshal(encoded, &state)
+		if err != nil {
+			return nil, err
+		}
+		return &state, nil
+	}
+
+	if sec.HasKey(ExpiresKey) {
+		expires, err := sec.Key(ExpiresKey).Time()
+		if err != nil {
+			return nil, err
+		}
+		return &SessionState{Version: SessionStateVersion, Profile: sec.Name(), Expiry: expires}, nil
+	}
+
+	return nil, nil
+}
+
+// starts a detached stscreds that restores the previous credentials once
+// elevations end, so other tools aren't left with expired credentials
+func scheduleRestore() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, fmt.Sprintf("--backups=%d", BackupCount), "--insecure-permissions="+PermissionPolicy, "restore-elevations")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// waits for elevations to end and restores the credentials they replaced,
+// until none are left. Started by elevate.
+type RestoreElevationsCommand struct {
+}
+
+func (cmd *RestoreElevationsCommand) Execute() error {
+	elevations, err := DefaultElevations()
+	if err != nil {
+		return err
+	}
+
+	// one waiter is enough; later ones wait their turn and find nothing left
+	unlock, err := lockFile(elevations.path + ".waiter")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for {
+		next, ok, err := elevations.NextEnd()
+		if err != nil || !ok {
+			return err
+		}
+
+		// sleep in steps, since sleeping doesn't count time suspended and
+		// a later elevation may end sooner
+		if wait := next.Sub(Now()); wait > 0 {
+			if wait > time.Minute {
+				wait = time.Minute
+			}
+			time.Sleep(wait)
+			continue
+		}
+
+		err = elevations.RestoreExpired(Now())
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// records why restore-elevations failed in the audit log, since it runs
+// detached with nowhere to report errors
+func RecordRestoreFailure(failure error) error {
+	return appendAuditLog(&AuditEvent{
+		Time:  time.Now().UTC(),
+		Event: "restore-failed",
+		User:  localUsername(),
+		Error: failure.Error(),
+	})
+}
+
+// an entry in ~/.stscreds/audit.log
+type AuditEvent struct {
+	Time    time.Time  `json:"time"`
+	Event   string     `json:"event"`
+	User    string     `json:"user"`
+	Profile string     `json:"profile,omitempty"`
+	RoleArn string     `json:"role_arn,omitempty"`
+	Reason  string     `json:"reason,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+func appendAuditLog(event *AuditEvent) error {
+	path, err := stscredsPath("audit.log")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(event)
+}
+
+func localUsername() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return u.Username
+}
@@ -0,0 +1,160 @@
+package stscreds
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// the kinds of failure that scripts can tell apart by stscreds' exit code
+type ErrorKind string
+
+const (
+	ErrOther               ErrorKind = "error"
+	ErrInteractionRequired ErrorKind = "interaction_required"
+	ErrNotInitialised      ErrorKind = "not_initialised"
+	ErrConfig              ErrorKind = "config"
+	ErrInvalidKey          ErrorKind = "invalid_key"
+	ErrMFAFailed           ErrorKind = "mfa_failed"
+	ErrAccessDenied        ErrorKind = "access_denied"
+	ErrThrottled           ErrorKind = "throttled"
+	ErrNetwork             ErrorKind = "network"
+	ErrClockSkew           ErrorKind = "clock_skew"
+	ErrChecksFailed        ErrorKind = "checks_failed"
+)
+
+// exit codes are documented in the README; don't change them
+var exitCodes = map[ErrorKind]int{
+	ErrOther:               2,
+	ErrInteractionRequired: 3,
+	ErrNotInitialised:      4,
+	ErrConfig:              5,
+	ErrInvalidKey:          6,
+	ErrMFAFailed:           7,
+	ErrAccessDenied:        8,
+	ErrThrottled:           9,
+	ErrNetwork:             10,
+	ErrClockSkew:           11,
+	ErrChecksFailed:        12,
+}
+
+// an error of a known kind
+type Error struct {
+	Kind    ErrorKind
+	Message string
+
+	// the AWS error code behind the failure, if there was one
+	AWSCode string
+
+	// explains the failure and how to fix it, when it's a known one
+	Hint string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func errorf(kind ErrorKind, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// describes a failed AWS request, classifying it by its error code
+func awsError(err error, format string, args ...interface{}) error {
+	kind, code := classifyAWSError(err)
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...) + ": " + err.Error(), AWSCode: code, Hint: diagnose(err)}
+}
+
+// describes an MFA code STS refused
+func mfaError(err error, format string, args ...interface{}) error {
+	e := awsError(err, format, args...).(*Error)
+	e.Kind = ErrMFAFailed
+	return e
+}
+
+// adds context to an error's message, keeping its kind
+func wrapError(err error, format string, args ...interface{}) error {
+	switch e := err.(type) {
+	case *Error:
+		return &Error{Kind: e.Kind, Message: fmt.Sprintf(format, args...) + ": " + e.Message, AWSCode: e.AWSCode, Hint: e.Hint}
+	case InteractionRequiredErr:
+		return err
+	}
+	return fmt.Errorf("%s: %s", fmt.Sprintf(format, args...), err.Error())
+}
+
+func classifyAWSError(err error) (ErrorKind, string) {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return ErrOther, ""
+	}
+
+	code := aerr.Code()
+	message := strings.ToLower(aerr.Message())
+	switch code {
+	case "InvalidClientTokenId", "SignatureDoesNotMatch", "InvalidAccessKeyId", "UnrecognizedClientException":
+		if strings.Contains(message, "signature expired") || strings.Contains(message, "not yet current") {
+			return ErrClockSkew, code
+		}
+		return ErrInvalidKey, code
+	case "RequestExpired", "RequestTimeTooSkewed":
+		return ErrClockSkew, code
+	case "AccessDenied", "AccessDeniedException", "UnauthorizedOperation":
+		// other AccessDenied errors can name MFA, e.g. iam:ListMFADevices;
+		// codes refused by GetSessionToken are classified where it's called
+		if strings.Contains(message, "multifactorauthentication") {
+			return ErrMFAFailed, code
+		}
+		return ErrAccessDenied, code
+	case "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException":
+		return ErrThrottled, code
+	case "RequestError", "RequestCanceled":
+		return ErrNetwork, code
+	case "RegionDisabledException":
+		return ErrConfig, code
+	}
+	return ErrOther, code
+}
+
+func kindOf(err error) ErrorKind {
+	switch e := err.(type) {
+	case *Error:
+		return e.Kind
+	case InteractionRequiredErr:
+		return ErrInteractionRequired
+	}
+	return ErrOther
+}
+
+// how to fix err, or "" if it's not known
+func ErrorHint(err error) string {
+	if e, ok := err.(*Error); ok {
+		return e.Hint
+	}
+	return ""
+}
+
+// the exit code stscreds uses for err
+func ExitCode(err error) int {
+	return exitCodes[kindOf(err)]
+}
+
+type jsonError struct {
+	Kind     ErrorKind `json:"kind"`
+	Message  string    `json:"message"`
+	AWSCode  string    `json:"aws_code,omitempty"`
+	Hint     string    `json:"hint,omitempty"`
+	ExitCode int       `json:"exit_code"`
+}
+
+// err as a line of json for scripts
+func ErrorJSON(err error) []byte {
+	e := jsonError{Kind: kindOf(err), Message: err.Error(), ExitCode: ExitCode(err)}
+	if typed, ok := err.(*Error); ok {
+		e.AWSCode = typed.AWSCode
+		e.Hint = typed.Hint
+	}
+	out, _ := json.Marshal(map[string]jsonError{"error": e})
+	return append(out, '\n')
+}
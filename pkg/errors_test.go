@@ -0,0 +1,35 @@
+package stscreds
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestClassifyAWSError(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind ErrorKind
+		code string
+	}{
+		{errors.New("not from aws"), ErrOther, ""},
+		{awserr.New("InvalidClientTokenId", "The security token included in the request is invalid.", nil), ErrInvalidKey, "InvalidClientTokenId"},
+		{awserr.New("SignatureDoesNotMatch", "Signature expired: 20200101T000000Z is now earlier than 20200101T000500Z", nil), ErrClockSkew, "SignatureDoesNotMatch"},
+		{awserr.New("RequestExpired", "Request has expired.", nil), ErrClockSkew, "RequestExpired"},
+		{awserr.New("AccessDenied", "MultiFactorAuthentication failed with invalid MFA one time pass code.", nil), ErrMFAFailed, "AccessDenied"},
+		{awserr.New("AccessDenied", "User: arn:aws:iam::123456789012:user/foo is not authorized to perform: iam:ListMFADevices on resource: user foo", nil), ErrAccessDenied, "AccessDenied"},
+		{awserr.New("AccessDenied", "User: arn:aws:iam::123456789012:user/foo is not authorized to perform: sts:AssumeRole on resource: arn:aws:iam::123456789012:role/admin", nil), ErrAccessDenied, "AccessDenied"},
+		{awserr.New("Throttling", "Rate exceeded", nil), ErrThrottled, "Throttling"},
+		{awserr.New("RequestError", "send request failed", nil), ErrNetwork, "RequestError"},
+		{awserr.New("RegionDisabledException", "STS is not activated in this region.", nil), ErrConfig, "RegionDisabledException"},
+		{awserr.New("ValidationError", "1 validation error detected", nil), ErrOther, "ValidationError"},
+	}
+
+	for _, test := range tests {
+		kind, code := classifyAWSError(test.err)
+		if kind != test.kind || code != test.code {
+			t.Errorf("classifyAWSError(%q) = %s, %q, want %s, %q", test.err.Error(), kind, code, test.kind, test.code)
+		}
+	}
+}
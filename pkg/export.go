@@ -0,0 +1,103 @@
+package stscreds
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// writes stscreds' role profiles into ~/.aws/config so tools that don't
+// call stscreds can use them.
+type ExportConfigCommand struct {
+	// use credential_process entries that call stscreds, rather than
+	// role_arn and source_profile entries the SDKs assume themselves
+	CredentialProcess bool
+	DryRun            bool
+}
+
+// settings written for role_arn profiles, removed when switching to
+// credential_process
+var exportedRoleKeys = []string{"role_arn", "source_profile", "external_id", "duration_seconds"}
+
+func (cmd *ExportConfigCommand) Execute() error {
+	config, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+
+	roles, err := config.configRoleProfiles()
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(executable, " \t") {
+		executable = strconv.Quote(executable)
+	}
+
+	return editWithDiff(config.awsConfigPath, cmd.DryRun, func(awsConfig *iniEditor) error {
+		exported := 0
+		for _, role := range roles {
+			section := awsConfigSection(role.Name)
+
+			// the SDKs would assume these roles without the session's
+			// restrictions or tags, so they're left for credential_process
+			if unsupported := unexportableSettings(role); !cmd.CredentialProcess && len(unsupported) > 0 {
+				fmt.Fprintf(os.Stderr, "warning: skipping %s: %s can't be set with role_arn; use --credential-process\n", role.Name, strings.Join(unsupported, ", "))
+				continue
+			}
+			exported++
+
+			if cmd.CredentialProcess {
+				for _, key := range exportedRoleKeys {
+					awsConfig.DeleteKey(section, key)
+				}
+				awsConfig.Set(section, "credential_process", fmt.Sprintf("%s credential-process --profile %s", executable, role.Name))
+			} else {
+				awsConfig.DeleteKey(section, "credential_process")
+				awsConfig.Set(section, "role_arn", role.RoleArn)
+				awsConfig.Set(section, "source_profile", role.SourceProfile)
+				awsConfig.Set(section, "duration_seconds", strconv.Itoa(int(role.Duration.Seconds())))
+				if role.ExternalID != "" {
+					awsConfig.Set(section, "external_id", role.ExternalID)
+				}
+			}
+
+			if role.Region != "" {
+				awsConfig.Set(section, "region", role.Region)
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, "Exporting %d role profiles\n", exported)
+		return nil
+	})
+}
+
+// the role's settings that ~/.aws/config role_arn profiles have no
+// equivalent for
+func unexportableSettings(role *RoleProfile) []string {
+	var settings []string
+	if len(role.Tags) > 0 {
+		settings = append(settings, "tags")
+	}
+	if len(role.TransitiveTagKeys) > 0 {
+		settings = append(settings, "transitive_tag_keys")
+	}
+	if role.Policy != "" {
+		settings = append(settings, "policy")
+	}
+	if len(role.PolicyArns) > 0 {
+		settings = append(settings, "policy_arns")
+	}
+	if role.SourceIdentity != "" && role.SourceIdentity != NoSourceIdentity {
+		settings = append(settings, "source_identity")
+	}
+	if role.SessionName != DefaultSessionNameTemplate {
+		settings = append(settings, "session_name")
+	}
+	return settings
+}
@@ -0,0 +1,91 @@
+package stscreds
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// the number of roles assumed at once when none is given
+const DefaultParallelism = 4
+
+// assumes every role, prompting for an MFA token at most once per source
+// profile. Source profiles are authenticated first and any failure stops
+// the whole group, since nothing can be assumed without them. Roles are then
+// assumed concurrently; a failure is reported without stopping the others.
+func (cmd *AuthCommand) authenticateGroup(roles []*RoleProfile) error {
+	if len(roles) == 0 {
+		return fmt.Errorf("no role profiles to authenticate")
+	}
+
+	inputs := make(map[string]*sts.AssumeRoleInput)
+	for _, role := range roles {
+		input, err := cmd.sessionPolicies(role)
+		if err != nil {
+			return wrapError(err, "%s", role.Name)
+		}
+		inputs[role.Name] = input
+	}
+
+	bases := make(map[string]*baseSession)
+	for _, role := range roles {
+		if _, ok := bases[role.SourceProfile]; ok {
+			continue
+		}
+		base, err := cmd.baseSession(role.SourceProfile, role.MFASerial)
+		if err != nil {
+			return wrapError(err, "source profile %s", role.SourceProfile)
+		}
+		bases[role.SourceProfile] = base
+	}
+
+	parallelism := cmd.Parallelism
+	if parallelism < 1 {
+		parallelism = DefaultParallelism
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed int
+	)
+	sem := make(chan struct{}, parallelism)
+
+	for _, role := range roles {
+		wg.Add(1)
+		go func(role *RoleProfile) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			var generatedCredentials *Credentials
+			unlock, err := lockProfile(role.Name)
+			if err == nil {
+				defer unlock()
+				generatedCredentials, err = cmd.assumeRoleFrom(bases[role.SourceProfile], role, inputs[role.Name])
+			}
+
+			// credentials files are read and rewritten whole, so saves are
+			// serialised
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				err = saveCredentials(role.Name, generatedCredentials, role.sessionState())
+			}
+			if err != nil {
+				failed++
+				fmt.Fprintf(os.Stderr, "%s: %s\n", role.Name, err.Error())
+			}
+		}(role)
+	}
+
+	wg.Wait()
+
+	if failed > 0 {
+		return fmt.Errorf("failed to authenticate %d of %d profiles", failed, len(roles))
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package stscreds
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// points $HOME and $STSCREDS_HOME at a temporary directory for a test,
+// returning it and a function that puts them back and removes it
+func testHome(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "stscreds")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restore := map[string]string{}
+	for _, name := range []string{"HOME", "STSCREDS_HOME", "AWS_SHARED_CREDENTIALS_FILE", "AWS_CONFIG_FILE"} {
+		restore[name] = os.Getenv(name)
+	}
+	os.Setenv("HOME", dir)
+	os.Setenv("STSCREDS_HOME", dir+"/.stscreds")
+	os.Unsetenv("AWS_SHARED_CREDENTIALS_FILE")
+	os.Unsetenv("AWS_CONFIG_FILE")
+
+	return dir, func() {
+		for name, value := range restore {
+			if value == "" {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, value)
+			}
+		}
+		os.RemoveAll(dir)
+	}
+}
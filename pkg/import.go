@@ -0,0 +1,51 @@
+package stscreds
+
+import (
+	"fmt"
+	"os"
+)
+
+// the aws cli settings stscreds understands for role profiles
+var awsConfigRoleKeys = []string{"role_arn", "source_profile", "mfa_serial", "duration_seconds", "external_id", "region"}
+
+// copies the role profiles in ~/.aws/config into ~/.stscreds/config
+type ImportCommand struct {
+	DryRun bool
+}
+
+func (cmd *ImportCommand) Execute() error {
+	config, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+
+	awsConfig, err := config.awsConfigFile()
+	if err != nil {
+		return err
+	}
+
+	return editWithDiff(config.path, cmd.DryRun, func(cfg *iniEditor) error {
+		imported := 0
+		for _, sec := range awsConfig.Sections() {
+			name := awsConfigProfile(sec.Name())
+			if name == "" || !sec.HasKey("role_arn") {
+				continue
+			}
+
+			if cfg.HasSection(name) {
+				fmt.Fprintf(os.Stderr, "Skipping %s, already in %s\n", name, config.path)
+				continue
+			}
+
+			for _, key := range awsConfigRoleKeys {
+				if sec.HasKey(key) {
+					cfg.Set(name, key, sec.Key(key).String())
+				}
+			}
+			imported++
+		}
+
+		fmt.Fprintf(os.Stderr, "Found %d role profiles to import from %s\n", imported, config.awsConfigPath)
+		return nil
+	})
+}
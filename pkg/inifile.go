@@ -0,0 +1,191 @@
+package stscreds
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// an ini file edited line by line. Unlike round-tripping through ini,
+// comments, blank lines, ordering and formatting are kept everywhere except
+// the keys that are changed.
+type iniEditor struct {
+	lines []string
+}
+
+func loadIniEditor(path string) (*iniEditor, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return newIniEditor(contents), nil
+}
+
+func newIniEditor(contents []byte) *iniEditor {
+	return &iniEditor{lines: splitLines(contents)}
+}
+
+func (e *iniEditor) Bytes() []byte {
+	if len(e.lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(e.lines, "\n") + "\n")
+}
+
+func sectionHeader(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+		return strings.TrimSpace(line[1 : len(line)-1]), true
+	}
+	return "", false
+}
+
+func keyName(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+		return "", false
+	}
+	i := strings.Index(trimmed, "=")
+	if i < 0 {
+		return "", false
+	}
+	return strings.TrimSpace(trimmed[:i]), true
+}
+
+// the line range [start, end) of a section, including its header; found is
+// false when the file has no such section.
+func (e *iniEditor) section(name string) (start, end int, found bool) {
+	start = -1
+	for i, line := range e.lines {
+		header, ok := sectionHeader(line)
+		if !ok {
+			continue
+		}
+		if start >= 0 {
+			return start, i, true
+		}
+		if header == name {
+			start = i
+		}
+	}
+	if start < 0 {
+		return 0, 0, false
+	}
+	return start, len(e.lines), true
+}
+
+func (e *iniEditor) HasSection(name string) bool {
+	_, _, found := e.section(name)
+	return found
+}
+
+// the value of a key, and whether it's set
+func (e *iniEditor) Get(section, key string) (string, bool) {
+	start, end, found := e.section(section)
+	if !found {
+		return "", false
+	}
+	for i := start + 1; i < end; i++ {
+		if name, ok := keyName(e.lines[i]); ok && name == key {
+			line := e.lines[i]
+			return strings.TrimSpace(line[strings.Index(line, "=")+1:]), true
+		}
+	}
+	return "", false
+}
+
+// the names of the keys in a section
+func (e *iniEditor) Keys(section string) []string {
+	start, end, found := e.section(section)
+	if !found {
+		return nil
+	}
+	var keys []string
+	for i := start + 1; i < end; i++ {
+		if name, ok := keyName(e.lines[i]); ok {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+// sets a key, keeping the formatting of an existing line. New keys are added
+// after the last key in the section and new sections at the end of the file.
+func (e *iniEditor) Set(section, key, value string) {
+	start, end, found := e.section(section)
+	if !found {
+		if len(e.lines) > 0 && strings.TrimSpace(e.lines[len(e.lines)-1]) != "" {
+			e.lines = append(e.lines, "")
+		}
+		e.lines = append(e.lines, "["+section+"]", key+" = "+value)
+		return
+	}
+
+	last := start
+	for i := start + 1; i < end; i++ {
+		name, ok := keyName(e.lines[i])
+		if !ok {
+			continue
+		}
+		if name == key {
+			line := e.lines[i]
+			sep := strings.Index(line, "=") + 1
+			for sep < len(line) && (line[sep] == ' ' || line[sep] == '\t') {
+				sep++
+			}
+			if sep == len(line) && strings.HasSuffix(line, "=") {
+				line += " "
+				sep++
+			}
+			e.lines[i] = line[:sep] + value
+			return
+		}
+		last = i
+	}
+
+	e.insert(last+1, key+" = "+value)
+}
+
+func (e *iniEditor) DeleteKey(section, key string) {
+	start, end, found := e.section(section)
+	if !found {
+		return
+	}
+	for i := start + 1; i < end; i++ {
+		if name, ok := keyName(e.lines[i]); ok && name == key {
+			e.lines = append(e.lines[:i], e.lines[i+1:]...)
+			return
+		}
+	}
+}
+
+// removes a section's header and keys. Comments and blank lines after its
+// last key are left, since they usually belong to the next section, but
+// blank lines doubled up or left at the end by the removal are dropped.
+func (e *iniEditor) DeleteSection(name string) {
+	start, end, found := e.section(name)
+	if !found {
+		return
+	}
+	last := start
+	for i := start + 1; i < end; i++ {
+		if _, ok := keyName(e.lines[i]); ok {
+			last = i
+		}
+	}
+	e.lines = append(e.lines[:start], e.lines[last+1:]...)
+
+	if start < len(e.lines) && strings.TrimSpace(e.lines[start]) == "" && (start == 0 || strings.TrimSpace(e.lines[start-1]) == "") {
+		e.lines = append(e.lines[:start], e.lines[start+1:]...)
+	}
+	for start == len(e.lines) && start > 0 && strings.TrimSpace(e.lines[start-1]) == "" {
+		e.lines = e.lines[:start-1]
+		start--
+	}
+}
+
+func (e *iniEditor) insert(i int, line string) {
+	e.lines = append(e.lines, "")
+	copy(e.lines[i+1:], e.lines[i:])
+	e.lines[i] = line
+}
@@ -0,0 +1,79 @@
+package stscreds
+
+import (
+	"testing"
+)
+
+func TestIniEditorSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		section string
+		key     string
+		value   string
+		out     string
+	}{
+		{"empty file", "", "default", "a", "1", "[default]\na = 1\n"},
+		{"new section", "[default]\na = 1\n", "work", "a", "2", "[default]\na = 1\n\n[work]\na = 2\n"},
+		{"existing key keeps formatting", "[default]\na=1\nb   =  2 \n", "default", "b", "3", "[default]\na=1\nb   =  3\n"},
+		{"empty value", "[default]\na =\n", "default", "a", "1", "[default]\na = 1\n"},
+		{"new key after last key", "[default]\na = 1\n\n# work\n[work]\n", "default", "b", "2", "[default]\na = 1\nb = 2\n\n# work\n[work]\n"},
+		{"key in other section untouched", "[default]\na = 1\n[work]\na = 2\n", "work", "a", "3", "[default]\na = 1\n[work]\na = 3\n"},
+		{"commented key isn't set", "[default]\n# a = 1\n", "default", "a", "2", "[default]\na = 2\n# a = 1\n"},
+	}
+
+	for _, test := range tests {
+		e := &iniEditor{lines: splitLines([]byte(test.in))}
+		e.Set(test.section, test.key, test.value)
+		if out := string(e.Bytes()); out != test.out {
+			t.Errorf("%s: got %q, want %q", test.name, out, test.out)
+		}
+	}
+}
+
+func TestIniEditorDeleteKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		section string
+		key     string
+		out     string
+	}{
+		{"missing section", "[default]\na = 1\n", "work", "a", "[default]\na = 1\n"},
+		{"missing key", "[default]\na = 1\n", "default", "b", "[default]\na = 1\n"},
+		{"keeps comments", "[default]\n# keys\na = 1\nb = 2\n", "default", "a", "[default]\n# keys\nb = 2\n"},
+		{"only in its section", "[default]\na = 1\n[work]\na = 2\n", "work", "a", "[default]\na = 1\n[work]\n"},
+	}
+
+	for _, test := range tests {
+		e := &iniEditor{lines: splitLines([]byte(test.in))}
+		e.DeleteKey(test.section, test.key)
+		if out := string(e.Bytes()); out != test.out {
+			t.Errorf("%s: got %q, want %q", test.name, out, test.out)
+		}
+	}
+}
+
+func TestIniEditorDeleteSection(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		section string
+		out     string
+	}{
+		{"missing section", "[default]\na = 1\n", "work", "[default]\na = 1\n"},
+		{"only section", "[default]\na = 1\n", "default", ""},
+		{"last section", "[default]\na = 1\n\n[work]\na = 2\n", "work", "[default]\na = 1\n"},
+		{"keeps the next section's comments", "[default]\na = 1\n\n# my work account, do not touch\n[work]\na = 2\n", "default", "# my work account, do not touch\n[work]\na = 2\n"},
+		{"between sections", "[a]\nx = 1\n\n[default]\na = 1\n\n[b]\ny = 2\n", "default", "[a]\nx = 1\n\n[b]\ny = 2\n"},
+		{"removes comments between its keys", "[default]\na = 1\n# secret\nb = 2\n[work]\n", "default", "[work]\n"},
+	}
+
+	for _, test := range tests {
+		e := &iniEditor{lines: splitLines([]byte(test.in))}
+		e.DeleteSection(test.section)
+		if out := string(e.Bytes()); out != test.out {
+			t.Errorf("%s: got %q, want %q", test.name, out, test.out)
+		}
+	}
+}
@@ -1,18 +1,25 @@
 package stscreds
 
 import (
-	"bufio"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"gopkg.in/ini.v1"
 	"os"
+	"regexp"
 	"strings"
 )
 
 type InitCommand struct {
 	Profile string
+
+	// where the keys are read from instead of prompting: the console's
+	// accessKeys.csv, JSON on stdin, the environment, or the profile's
+	// keys in ~/.aws/credentials, which are removed once copied
+	CSVPath string
+	JSON    bool
+	FromEnv bool
+	Migrate bool
 }
 
 func (c *InitCommand) credentialsFile(path string) (*ini.File, error) {
@@ -28,6 +35,12 @@ func (c *InitCommand) credentialsFile(path string) (*ini.File, error) {
 }
 
 func (c *InitCommand) writeFile(accessKey, secretKey, path string) error {
+	unlock, err := lockFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	cfg, err := c.credentialsFile(path)
 	if err != nil {
 		return err
@@ -37,25 +50,26 @@ func (c *InitCommand) writeFile(accessKey, secretKey, path string) error {
 	sec.NewKey("aws_access_key_id", accessKey)
 	sec.NewKey("aws_secret_access_key", secretKey)
 
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	return saveIni(cfg, path)
+}
 
-	if err != nil {
-		return err
-	}
-	w := bufio.NewWriter(f)
-	cfg.WriteTo(w)
-	w.Flush()
+// environment variables that take precedence over ~/.aws/credentials
+var credentialEnvironment = []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"}
 
-	return nil
+// the credential environment variables that are set
+func overridingEnvironment() []string {
+	var set []string
+	for _, name := range credentialEnvironment {
+		if os.Getenv(name) != "" {
+			set = append(set, name)
+		}
+	}
+	return set
 }
 
 func warnOnEnvironmentVariables() {
-	if os.Getenv("AWS_ACCESS_KEY_ID") != "" {
-		fmt.Fprintf(os.Stderr, "warning: AWS_ACCESS_KEY_ID environment variable set, may override sts credentials initialised in ~/.aws/credentials.\nwarning: AWS_ACCESS_KEY_ID should probably be removed from your environment; check ~/.bash_profile etc.\n")
-	}
-
-	if os.Getenv("AWS_SECRET_ACCESS_KEY") != "" {
-		fmt.Fprintf(os.Stderr, "warning: AWS_SECRET_ACCESS_KEY environment variable set, may override sts credentials initialised in ~/.aws/credentials.\nwarning: AWS_SECRET_ACCESS_KEY should probably be removed from your environment; check ~/.bash_profile etc.\n")
+	for _, name := range overridingEnvironment() {
+		fmt.Fprintf(os.Stderr, "warning: %s environment variable set, may override sts credentials initialised in ~/.aws/credentials.\nwarning: %s should probably be removed from your environment; check ~/.bash_profile etc.\n", name, name)
 	}
 }
 
@@ -64,8 +78,28 @@ type Keys struct {
 	SecretKey string
 }
 
+var (
+	accessKeyFormat = regexp.MustCompile(`^AKIA[A-Z0-9]{16}$`)
+	secretKeyFormat = regexp.MustCompile(`^[A-Za-z0-9/+]{40}$`)
+)
+
+// checks the keys look like an IAM user's long-term access key, without
+// calling AWS
+func (k *Keys) CheckFormat() error {
+	if strings.HasPrefix(k.AccessKey, "ASIA") {
+		return fmt.Errorf("%s is a temporary access key from STS; stscreds needs a long-term access key (starting AKIA) created for your IAM user", k.AccessKey)
+	}
+	if !accessKeyFormat.MatchString(k.AccessKey) {
+		return fmt.Errorf("access key should be 20 letters and numbers starting AKIA")
+	}
+	if !secretKeyFormat.MatchString(k.SecretKey) {
+		return fmt.Errorf("secret access key should be 40 characters of letters, numbers, / and +")
+	}
+	return nil
+}
+
 func (k *Keys) Valid() (bool, error) {
-	sess := session.New(&aws.Config{Credentials: credentials.NewStaticCredentials(k.AccessKey, k.SecretKey, "")})
+	sess := newSession(&aws.Config{Credentials: credentials.NewStaticCredentials(k.AccessKey, k.SecretKey, "")})
 	_, err := getUser(sess)
 	if err != nil {
 		return false, err
@@ -75,32 +109,62 @@ func (k *Keys) Valid() (bool, error) {
 }
 
 func readFromPrompt() (*Keys, error) {
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Fprintf(os.Stderr, "AWS Access Key: ")
-	text, err := reader.ReadString('\n')
+	p, err := openPrompt("an access key")
 	if err != nil {
 		return nil, err
 	}
-	accessKey := strings.Trim(text, " \r\n")
-	fmt.Fprintf(os.Stderr, "AWS Secret Access Key: ")
-	text, err = reader.ReadString('\n')
+	defer p.Close()
+
+	accessKey, err := p.ask("AWS Access Key: ")
+	if err != nil {
+		return nil, err
+	}
+	secretKey, err := p.askSecret("AWS Secret Access Key (hidden): ")
 	if err != nil {
 		return nil, err
 	}
-	secretKey := strings.Trim(text, " \r\n")
 
 	return &Keys{accessKey, secretKey}, nil
 }
 
-func readAWSKeys() (*Keys, error) {
-	keys, err := readFromPrompt()
+func (cmd *InitCommand) readKeys() (*Keys, error) {
+	sources := 0
+	for _, set := range []bool{cmd.CSVPath != "", cmd.JSON, cmd.FromEnv, cmd.Migrate} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, fmt.Errorf("only one of --csv, --json, --from-env and --migrate can be used")
+	}
+
+	switch {
+	case cmd.CSVPath != "":
+		return readKeysCSV(cmd.CSVPath)
+	case cmd.JSON:
+		return readKeysJSON(os.Stdin)
+	case cmd.FromEnv:
+		return readKeysEnv()
+	case cmd.Migrate:
+		return readKeysAWSCredentials(cmd.Profile)
+	}
+	return readFromPrompt()
+}
+
+func (cmd *InitCommand) readAWSKeys() (*Keys, error) {
+	keys, err := cmd.readKeys()
 	if err != nil {
 		return nil, err
 	}
 
+	err = keys.CheckFormat()
+	if err != nil {
+		return nil, &Error{Kind: ErrInvalidKey, Message: err.Error()}
+	}
+
 	_, err = keys.Valid()
 	if err != nil {
-		return nil, err
+		return nil, awsError(err, "keys weren't accepted")
 	}
 
 	return keys, err
@@ -114,9 +178,9 @@ func (cmd *InitCommand) Execute() error {
 		return err
 	}
 
-	keys, err := readAWSKeys()
+	keys, err := cmd.readAWSKeys()
 	if err != nil {
-		return fmt.Errorf("error with aws credentials: %s", err.Error())
+		return wrapError(err, "error with aws credentials")
 	}
 
 	err = creds.Initialise(keys)
@@ -126,5 +190,17 @@ func (cmd *InitCommand) Execute() error {
 
 	fmt.Fprintf(os.Stderr, "Successfully wrote %s\n", creds.path)
 
+	if cmd.Migrate {
+		tc, err := DefaultTemporaryCredentials(cmd.Profile)
+		if err != nil {
+			return err
+		}
+		err = tc.Remove()
+		if err != nil {
+			return fmt.Errorf("error removing long-term keys from %s: %s", tc.path, err.Error())
+		}
+		fmt.Fprintf(os.Stderr, "Removed long-term keys from %s\n", tc.path)
+	}
+
 	return nil
 }
@@ -0,0 +1,99 @@
+package stscreds
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// reads keys from the accessKeys.csv the AWS console downloads when an
+// access key is created. Older downloads also include the user name,
+// password and console link columns.
+func readKeysCSV(path string) (*Keys, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", path, err.Error())
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("%s has no access key", path)
+	}
+
+	accessKey, secretKey := -1, -1
+	for i, column := range rows[0] {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))) {
+		case "access key id":
+			accessKey = i
+		case "secret access key":
+			secretKey = i
+		}
+	}
+	row := rows[1]
+	if accessKey < 0 || secretKey < 0 || accessKey >= len(row) || secretKey >= len(row) {
+		return nil, fmt.Errorf("%s doesn't have Access key ID and Secret access key columns", path)
+	}
+
+	return &Keys{AccessKey: strings.TrimSpace(row[accessKey]), SecretKey: strings.TrimSpace(row[secretKey])}, nil
+}
+
+// the output of `aws iam create-access-key`, or just the access key within it
+type accessKeyJSON struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	AccessKey       *accessKeyJSON
+}
+
+// reads keys from JSON as printed by `aws iam create-access-key`, or with
+// AccessKeyId and SecretAccessKey at the top level
+func readKeysJSON(r io.Reader) (*Keys, error) {
+	var key accessKeyJSON
+	err := json.NewDecoder(r).Decode(&key)
+	if err != nil {
+		return nil, fmt.Errorf("error reading json: %s", err.Error())
+	}
+	if key.AccessKey != nil {
+		key = *key.AccessKey
+	}
+	if key.AccessKeyId == "" || key.SecretAccessKey == "" {
+		return nil, fmt.Errorf("json should have AccessKeyId and SecretAccessKey")
+	}
+
+	return &Keys{AccessKey: key.AccessKeyId, SecretKey: key.SecretAccessKey}, nil
+}
+
+func readKeysEnv() (*Keys, error) {
+	keys := &Keys{AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"), SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY")}
+	if keys.AccessKey == "" || keys.SecretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return keys, nil
+}
+
+// reads the long-term keys people kept in ~/.aws/credentials before using
+// stscreds
+func readKeysAWSCredentials(profile string) (*Keys, error) {
+	tc, err := DefaultTemporaryCredentials(profile)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := tc.Load()
+	if err != nil {
+		return nil, err
+	}
+	if creds == nil {
+		return nil, fmt.Errorf("no keys for %s in %s", profile, tc.path)
+	}
+	if creds.SessionToken != "" {
+		return nil, fmt.Errorf("%s in %s has a session token, so holds temporary credentials rather than your long-term keys", profile, tc.path)
+	}
+
+	return &Keys{AccessKey: creds.AccessKey, SecretKey: creds.SecretKey}, nil
+}
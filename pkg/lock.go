@@ -0,0 +1,179 @@
+package stscreds
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// the file path links to, so files kept elsewhere by dotfile managers are
+// written in place rather than replaced. Paths that don't exist yet are
+// returned as they are.
+func resolveSymlinks(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		return path, nil
+	}
+	return resolved, err
+}
+
+// takes an exclusive advisory lock on path+".lock", waiting for any other
+// process holding it. Hold it around a read-modify-write of path.
+func lockFile(path string) (unlock func(), err error) {
+	path, err = resolveSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// replaces path with data by writing a temporary file alongside it and
+// renaming it into place, so readers never see a partially written file.
+// When path is a symlink the file it links to is replaced.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	path, err := resolveSymlinks(path)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	// persist the rename
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func saveIni(cfg *ini.File, path string) error {
+	_, err := writeIni(cfg, path)
+	return err
+}
+
+// saves cfg to path, returning what was written
+func writeIni(cfg *ini.File, path string) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := cfg.WriteTo(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), writeFileAtomic(path, buf.Bytes(), 0600)
+}
+
+var (
+	// profiles this process holds the auth lock of
+	heldProfileLocks   = map[string]bool{}
+	heldProfileLocksMu sync.Mutex
+)
+
+// locks profile so only one process authenticates it at a time. Locking a
+// profile this process already holds, as auth does when it's run to
+// refresh credentials, does nothing.
+func lockProfile(profile string) (unlock func(), err error) {
+	heldProfileLocksMu.Lock()
+	held := heldProfileLocks[profile]
+	heldProfileLocksMu.Unlock()
+	if held {
+		return func() {}, nil
+	}
+
+	path, err := stscredsPath("locks", url.PathEscape(profile))
+	if err != nil {
+		return nil, err
+	}
+	unlockFile, err := lockFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	heldProfileLocksMu.Lock()
+	heldProfileLocks[profile] = true
+	heldProfileLocksMu.Unlock()
+
+	return func() {
+		heldProfileLocksMu.Lock()
+		delete(heldProfileLocks, profile)
+		heldProfileLocksMu.Unlock()
+		unlockFile()
+	}, nil
+}
+
+// runs authenticate to refresh the profile's credentials when they have less
+// than minTTL left. Only one process authenticates a profile at a time; a
+// process that waited while another refreshed the profile reuses its
+// credentials rather than prompting again.
+func RefreshCredentials(profile string, minTTL time.Duration, authenticate func() error) error {
+	unlock, err := lockProfile(profile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	valid, err := hasValidSession(profile, Now().Add(minTTL))
+	if err != nil {
+		return err
+	}
+	if valid {
+		return nil
+	}
+
+	return authenticate()
+}
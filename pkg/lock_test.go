@@ -0,0 +1,61 @@
+package stscreds
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockProfile(t *testing.T) {
+	_, cleanup := testHome(t)
+	defer cleanup()
+
+	unlock, err := lockProfile("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// auth run to refresh credentials locks the profile again
+	unlockAgain, err := lockProfile("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockAgain()
+
+	// the lock is still held against other processes, which open the lock
+	// file separately
+	path, _ := stscredsPath("locks", "prod")
+	locked := make(chan func())
+	go func() {
+		unlockFile, err := lockFile(path)
+		if err != nil {
+			t.Error(err)
+		}
+		locked <- unlockFile
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("another process locked the profile while it was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case unlockFile := <-locked:
+		unlockFile()
+	case <-time.After(time.Second):
+		t.Fatal("the profile wasn't unlocked")
+	}
+
+	// other profiles are locked separately
+	unlockProd, err := lockProfile("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlockProd()
+	unlockDev, err := lockProfile("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockDev()
+}
@@ -0,0 +1,150 @@
+package stscreds
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// how many MFA codes are asked for before giving up
+const DefaultMFAAttempts = 3
+
+// how often MFA devices show a new code
+const totpPeriod = 30 * time.Second
+
+// why STS refused an MFA code
+type mfaFailure int
+
+const (
+	notMFAFailure mfaFailure = iota
+	mfaCodeInvalid
+	mfaCodeReused
+)
+
+func classifyMFAError(err error) mfaFailure {
+	aerr, ok := err.(awserr.Error)
+	if !ok || aerr.Code() != "AccessDenied" {
+		return notMFAFailure
+	}
+	message := strings.ToLower(aerr.Message())
+	if !strings.Contains(message, "multifactorauthentication") {
+		return notMFAFailure
+	}
+	if strings.Contains(message, "already been used") || strings.Contains(message, "reuse") {
+		return mfaCodeReused
+	}
+	return mfaCodeInvalid
+}
+
+// how long until the device shows its next code
+func untilNextCode(now time.Time) time.Duration {
+	return totpPeriod - time.Duration(now.UnixNano()%int64(totpPeriod))
+}
+
+// requests a session token, asking for MFA codes until one is accepted or
+// attempts run out. A code that was just used is caught before it's sent,
+// and the user waits for the device to show the next one.
+func (cmd *AuthCommand) sessionTokenWithMFA(sess *session.Session, request *TokenRequest) (*Credentials, error) {
+	attempts := cmd.MFAAttempts
+	if attempts < 1 {
+		attempts = DefaultMFAAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		token, err := readToken(cmd.TokenReader, request)
+		if err != nil {
+			return nil, wrapError(err, "error requesting mfa token")
+		}
+
+		if used, err := lastUsedCode(request.MFASerial); err == nil && used != "" && used == token {
+			waitForNextCode(request)
+			attempt--
+			continue
+		}
+
+		creds, err := requestNewSTSToken(sess, request.MFASerial, token, cmd.Expiry)
+		if err == nil {
+			if err := recordUsedCode(request.MFASerial, token); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: couldn't record MFA code: %s\n", err.Error())
+			}
+			return creds, nil
+		}
+
+		failure := classifyMFAError(err)
+		if failure == notMFAFailure {
+			return nil, awsError(err, "error requesting credentials")
+		}
+		if failure == mfaCodeReused {
+			recordUsedCode(request.MFASerial, token)
+		}
+
+		// checked before waiting for the next code, which wouldn't be asked for
+		if attempt >= attempts {
+			return nil, mfaError(err, "error requesting credentials: MFA failed after %d attempts", attempts)
+		}
+
+		if failure == mfaCodeReused {
+			waitForNextCode(request)
+		} else {
+			request.Error = fmt.Sprintf("%s wasn't accepted. Check you're using the device %s and try again; each code can only be used once.", token, request.MFASerial)
+		}
+	}
+}
+
+func waitForNextCode(request *TokenRequest) {
+	wait := untilNextCode(Now())
+	fmt.Fprintf(os.Stderr, "That MFA code has already been used, waiting %s for your device to show the next one\n", wait.Round(time.Second))
+	time.Sleep(wait)
+	request.Error = "Enter the next code shown by your device."
+}
+
+// the last code used with each MFA device is kept, so it isn't sent again
+func usedCodesPath() (string, error) {
+	return stscredsPath("mfa")
+}
+
+// the last code used with the device, if it could still be showing
+func lastUsedCode(serial string) (string, error) {
+	path, err := usedCodesPath()
+	if err != nil {
+		return "", err
+	}
+	editor, err := loadIniEditor(path)
+	if err != nil {
+		return "", err
+	}
+
+	code, _ := editor.Get(serial, "code")
+	usedAt, _ := editor.Get(serial, "used_at")
+	at, err := time.Parse(time.RFC3339, usedAt)
+	if err != nil || time.Since(at) > 2*totpPeriod {
+		return "", nil
+	}
+	return code, nil
+}
+
+func recordUsedCode(serial, code string) error {
+	path, err := usedCodesPath()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	editor, err := loadIniEditor(path)
+	if err != nil {
+		return err
+	}
+	editor.Set(serial, "code", code)
+	editor.Set(serial, "used_at", time.Now().UTC().Format(time.RFC3339))
+
+	return writeFileAtomic(path, editor.Bytes(), 0600)
+}
@@ -0,0 +1,99 @@
+package stscreds
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// what to do about credential files other users can access
+const (
+	FixPermissions    = "fix"
+	RefusePermissions = "refuse"
+)
+
+// the policy this stscreds was run with, passed on to processes it starts
+var PermissionPolicy = FixPermissions
+
+// files holding secrets, or state about them, that only their owner should
+// be able to read: ~/.aws/credentials and everything under ~/.stscreds,
+// including backups, session state and the directories holding them
+func credentialFiles() ([]string, error) {
+	awsCredentials, err := awsCredentialsPath()
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{awsCredentials}
+
+	dir, err := stscredsDir()
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// links are left alone; what they point to isn't ours to change
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
+
+// checks credential files and directories aren't accessible to other users,
+// either fixing their permissions (0600 for files, 0700 for directories) or
+// refusing to continue depending on policy. Files owned by another user are
+// reported.
+func CheckPermissions(policy string) error {
+	paths, err := credentialFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if stat, ok := fi.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+			fmt.Fprintf(os.Stderr, "warning: %s is owned by uid %d, not the current user (uid %d)\n", path, stat.Uid, os.Getuid())
+		}
+
+		mode := fi.Mode().Perm()
+		if mode&0077 == 0 {
+			continue
+		}
+
+		want := os.FileMode(0600)
+		if fi.IsDir() {
+			want = 0700
+		}
+
+		if policy == RefusePermissions {
+			return fmt.Errorf("%s is accessible to other users (mode %04o), run: chmod %o %s", path, mode, want, path)
+		}
+
+		err = os.Chmod(path, want)
+		if err != nil {
+			return fmt.Errorf("error fixing permissions of %s: %s", path, err.Error())
+		}
+		fmt.Fprintf(os.Stderr, "warning: %s was accessible to other users (mode %04o), changed to %04o\n", path, mode, want)
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package stscreds
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckPermissions(t *testing.T) {
+	dir, cleanup := testHome(t)
+	defer cleanup()
+
+	stscreds := filepath.Join(dir, ".stscreds")
+	os.MkdirAll(filepath.Join(stscreds, "backups", ".aws_credentials"), 0755)
+	os.MkdirAll(filepath.Join(stscreds, "state"), 0755)
+	os.MkdirAll(filepath.Join(dir, ".aws"), 0700)
+
+	tests := []struct {
+		path string
+		mode os.FileMode
+	}{
+		{stscreds, 0700},
+		{filepath.Join(stscreds, "backups"), 0700},
+		{filepath.Join(stscreds, "backups", ".aws_credentials"), 0700},
+		{filepath.Join(stscreds, "backups", ".aws_credentials", "20200101T120000.000000000Z"), 0600},
+		{filepath.Join(stscreds, "state"), 0700},
+		{filepath.Join(stscreds, "state", "default.json"), 0600},
+		{filepath.Join(stscreds, "mfa"), 0600},
+		{filepath.Join(stscreds, "clock"), 0600},
+		{filepath.Join(dir, ".aws", "credentials"), 0600},
+	}
+	for _, test := range tests {
+		if test.mode == 0600 {
+			ioutil.WriteFile(test.path, nil, 0644)
+		}
+		os.Chmod(test.path, test.mode|0055)
+	}
+
+	if err := CheckPermissions(RefusePermissions); err == nil {
+		t.Fatal("expected refusing to continue with insecure permissions")
+	}
+	if err := CheckPermissions(FixPermissions); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		fi, err := os.Stat(test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := fi.Mode().Perm(); mode != test.mode {
+			t.Errorf("%s: got mode %04o, want %04o", test.path, mode, test.mode)
+		}
+	}
+
+	if err := CheckPermissions(RefusePermissions); err != nil {
+		t.Errorf("fixed permissions still refused: %s", err.Error())
+	}
+}
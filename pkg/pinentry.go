@@ -0,0 +1,153 @@
+package stscreds
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// asks for MFA tokens through a pinentry program (pinentry, pinentry-curses,
+// pinentry-gnome3 etc.), as gpg-agent does, so tools that run stscreds
+// without a terminal can still prompt
+type PinentryTokenReader struct {
+	Program string
+}
+
+func (r *PinentryTokenReader) Read() (string, error) {
+	return r.ReadRequest(&TokenRequest{})
+}
+
+func (r *PinentryTokenReader) ReadRequest(request *TokenRequest) (string, error) {
+	description := "Enter your MFA code."
+	if request.Username != "" {
+		description = fmt.Sprintf("Enter the MFA code for %s to authenticate profile %s.\n\nMFA device: %s", request.Username, request.Profile, request.MFASerial)
+	}
+	return pinentry(r.Program, &pinentryPrompt{
+		Title:       "stscreds",
+		Description: description,
+		Prompt:      "MFA code:",
+		Error:       request.Error,
+	})
+}
+
+type pinentryPrompt struct {
+	Title       string
+	Description string
+	Prompt      string
+
+	// shown when asking again after a failure
+	Error string
+}
+
+// the error code pinentry returns when the prompt is cancelled
+const pinentryCancelled = "83886179"
+
+// runs program and asks for a secret over the Assuan protocol
+func pinentry(program string, prompt *pinentryPrompt) (string, error) {
+	cmd := exec.Command(program)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Start()
+	if err != nil {
+		return "", fmt.Errorf("error running %s: %s", program, err.Error())
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+
+	conn := &assuanConn{reader: bufio.NewReader(stdout), writer: stdin}
+
+	// the greeting
+	_, err = conn.response()
+	if err != nil {
+		return "", err
+	}
+
+	commands := []string{"SETTITLE " + assuanEscape(prompt.Title)}
+	if tty := os.Getenv("GPG_TTY"); tty != "" {
+		commands = append(commands, "OPTION ttyname="+tty)
+	}
+	if term := os.Getenv("TERM"); term != "" {
+		commands = append(commands, "OPTION ttytype="+term)
+	}
+	commands = append(commands,
+		"SETDESC "+assuanEscape(prompt.Description),
+		"SETPROMPT "+assuanEscape(prompt.Prompt),
+	)
+	if prompt.Error != "" {
+		commands = append(commands, "SETERROR "+assuanEscape(prompt.Error))
+	}
+	for _, command := range commands {
+		_, err = conn.command(command)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	pin, err := conn.command("GETPIN")
+	if err != nil {
+		return "", err
+	}
+
+	conn.command("BYE")
+	return pin, nil
+}
+
+type assuanConn struct {
+	reader *bufio.Reader
+	writer io.Writer
+}
+
+func (c *assuanConn) command(line string) (string, error) {
+	_, err := fmt.Fprintf(c.writer, "%s\n", line)
+	if err != nil {
+		return "", err
+	}
+	return c.response()
+}
+
+// reads lines up to OK or ERR, returning the data sent with D lines
+func (c *assuanConn) response() (string, error) {
+	var data string
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("error reading from pinentry: %s", err.Error())
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data, nil
+		case strings.HasPrefix(line, "ERR "):
+			fields := strings.SplitN(line, " ", 3)
+			if fields[1] == pinentryCancelled {
+				return "", fmt.Errorf("cancelled")
+			}
+			return "", fmt.Errorf("pinentry: %s", strings.Join(fields[1:], " "))
+		case strings.HasPrefix(line, "D "):
+			decoded, err := url.PathUnescape(line[2:])
+			if err != nil {
+				return "", err
+			}
+			data += decoded
+		}
+		// status (S) and comment (#) lines are ignored
+	}
+}
+
+// percent-escapes what Assuan doesn't allow in a line
+func assuanEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
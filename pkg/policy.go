@@ -0,0 +1,172 @@
+package stscreds
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// a string or list of strings in a policy document
+type stringOrSlice []string
+
+func (s *stringOrSlice) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*s = []string{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+type policyStatement struct {
+	Effect      string
+	Action      stringOrSlice
+	NotAction   stringOrSlice
+	Resource    stringOrSlice
+	NotResource stringOrSlice
+	Condition   map[string]json.RawMessage
+}
+
+// a statement, or list of statements, in a policy document
+type policyStatements []policyStatement
+
+func (s *policyStatements) UnmarshalJSON(b []byte) error {
+	var single policyStatement
+	if err := json.Unmarshal(b, &single); err == nil {
+		*s = []policyStatement{single}
+		return nil
+	}
+
+	var list []policyStatement
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+type policyDocument struct {
+	Statement policyStatements
+}
+
+// IAM returns policy documents URL encoded
+func parsePolicyDocument(encoded string) (*policyDocument, error) {
+	decoded, err := url.QueryUnescape(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc policyDocument
+	err = json.Unmarshal([]byte(decoded), &doc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy document: %s", err.Error())
+	}
+	return &doc, nil
+}
+
+// the resources the documents allow action on, excluding those explicitly
+// denied. Resources may contain wildcards; those matching wildcards should
+// be checked with resourceAllowed once expanded. Statements allowing all
+// but their NotResource allow "*".
+func allowedResources(documents []*policyDocument, action string) []string {
+	var resources []string
+	seen := make(map[string]bool)
+
+	for _, doc := range documents {
+		for _, statement := range doc.Statement {
+			if !strings.EqualFold(statement.Effect, "Allow") || !statement.appliesTo(action) {
+				continue
+			}
+			candidates := statement.Resource
+			if len(statement.NotResource) > 0 {
+				candidates = []string{"*"}
+			}
+			for _, resource := range candidates {
+				if !seen[resource] && resourceAllowed(documents, action, resource) {
+					seen[resource] = true
+					resources = append(resources, resource)
+				}
+			}
+		}
+	}
+
+	return resources
+}
+
+// whether the documents allow action on resource. Denies with conditions
+// are ignored: they usually require MFA, which stscreds' sessions have.
+func resourceAllowed(documents []*policyDocument, action, resource string) bool {
+	allowed := false
+	for _, doc := range documents {
+		for _, statement := range doc.Statement {
+			if !statement.appliesTo(action) || !statement.coversResource(resource) {
+				continue
+			}
+			if !strings.EqualFold(statement.Effect, "Deny") {
+				allowed = true
+				continue
+			}
+			if len(statement.Condition) == 0 {
+				return false
+			}
+		}
+	}
+	return allowed
+}
+
+// whether the statement's Resource, or everything but its NotResource,
+// includes resource
+func (s *policyStatement) coversResource(resource string) bool {
+	if len(s.NotResource) > 0 {
+		for _, pattern := range s.NotResource {
+			if wildcardMatch(pattern, resource, false) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, pattern := range s.Resource {
+		if wildcardMatch(pattern, resource, false) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *policyStatement) appliesTo(action string) bool {
+	if len(s.NotAction) > 0 {
+		for _, pattern := range s.NotAction {
+			if wildcardMatch(pattern, action, true) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, pattern := range s.Action {
+		if wildcardMatch(pattern, action, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches s against a pattern using IAM's * and ? wildcards
+func wildcardMatch(pattern, s string, ignoreCase bool) bool {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	return regexp.MustCompile("^" + expr + "$").MatchString(s)
+}
@@ -0,0 +1,134 @@
+package stscreds
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+const requireMFAStatement = `{
+	"Effect": "Deny",
+	"NotAction": ["iam:GetUser", "iam:ListMFADevices", "sts:GetSessionToken"],
+	"Resource": "*",
+	"Condition": {"BoolIfExists": {"aws:MultiFactorAuthPresent": "false"}}
+}`
+
+func parsePolicies(t *testing.T, policies ...string) []*policyDocument {
+	var documents []*policyDocument
+	for _, policy := range policies {
+		doc, err := parsePolicyDocument(url.QueryEscape(policy))
+		if err != nil {
+			t.Fatalf("%s: %s", policy, err.Error())
+		}
+		documents = append(documents, doc)
+	}
+	return documents
+}
+
+func TestAllowedResources(t *testing.T) {
+	tests := []struct {
+		name      string
+		policies  []string
+		resources []string
+	}{
+		{
+			"single statement",
+			[]string{`{"Statement": {"Effect": "Allow", "Action": "sts:AssumeRole", "Resource": "arn:aws:iam::1:role/dev"}}`},
+			[]string{"arn:aws:iam::1:role/dev"},
+		},
+		{
+			"other actions",
+			[]string{`{"Statement": [{"Effect": "Allow", "Action": "s3:*", "Resource": "*"}, {"Effect": "Allow", "Action": "sts:Assume*", "Resource": ["arn:aws:iam::1:role/a", "arn:aws:iam::2:role/*"]}]}`},
+			[]string{"arn:aws:iam::1:role/a", "arn:aws:iam::2:role/*"},
+		},
+		{
+			"requiring MFA",
+			[]string{
+				`{"Statement": [{"Effect": "Allow", "Action": "sts:AssumeRole", "Resource": "arn:aws:iam::1:role/admin"}, ` + requireMFAStatement + `]}`,
+			},
+			[]string{"arn:aws:iam::1:role/admin"},
+		},
+		{
+			"unconditional deny everything",
+			[]string{`{"Statement": [{"Effect": "Allow", "Action": "sts:AssumeRole", "Resource": "arn:aws:iam::1:role/admin"}, {"Effect": "Deny", "Action": "*", "Resource": "*"}]}`},
+			nil,
+		},
+		{
+			"wildcard deny",
+			[]string{
+				`{"Statement": {"Effect": "Allow", "Action": "sts:AssumeRole", "Resource": ["arn:aws:iam::1:role/admin-x", "arn:aws:iam::1:role/dev"]}}`,
+				`{"Statement": {"Effect": "Deny", "Action": "sts:AssumeRole", "Resource": "arn:aws:iam::*:role/admin-*"}}`,
+			},
+			[]string{"arn:aws:iam::1:role/dev"},
+		},
+		{
+			"deny NotResource",
+			[]string{`{"Statement": [{"Effect": "Allow", "Action": "sts:AssumeRole", "Resource": ["arn:aws:iam::1:role/a", "arn:aws:iam::2:role/b"]}, {"Effect": "Deny", "Action": "sts:AssumeRole", "NotResource": "arn:aws:iam::1:*"}]}`},
+			[]string{"arn:aws:iam::1:role/a"},
+		},
+		{
+			"allow NotResource",
+			[]string{`{"Statement": {"Effect": "Allow", "Action": "sts:AssumeRole", "NotResource": "arn:aws:iam::1:role/admin"}}`},
+			[]string{"*"},
+		},
+		{
+			"NotAction deny of other actions",
+			[]string{`{"Statement": [{"Effect": "Allow", "Action": "sts:AssumeRole", "Resource": "arn:aws:iam::1:role/a"}, {"Effect": "Deny", "NotAction": "sts:*", "Resource": "*"}]}`},
+			[]string{"arn:aws:iam::1:role/a"},
+		},
+	}
+
+	for _, test := range tests {
+		resources := allowedResources(parsePolicies(t, test.policies...), "sts:AssumeRole")
+		if !reflect.DeepEqual(resources, test.resources) {
+			t.Errorf("%s: got %q, want %q", test.name, resources, test.resources)
+		}
+	}
+}
+
+func TestResourceAllowed(t *testing.T) {
+	documents := parsePolicies(t,
+		`{"Statement": [{"Effect": "Allow", "Action": "sts:AssumeRole", "Resource": "arn:aws:iam::1:role/*"}, `+requireMFAStatement+`]}`,
+		`{"Statement": [{"Effect": "Deny", "Action": "sts:AssumeRole", "Resource": "arn:aws:iam::*:role/admin-*"}, {"Effect": "Allow", "Action": "sts:AssumeRole", "NotResource": "arn:aws:iam::1:*"}]}`,
+		`{"Statement": {"Effect": "Deny", "Action": "sts:AssumeRole", "NotResource": ["arn:aws:iam::1:*", "arn:aws:iam::2:*"]}}`,
+	)
+
+	tests := []struct {
+		arn     string
+		allowed bool
+	}{
+		{"arn:aws:iam::1:role/dev", true},
+		{"arn:aws:iam::1:role/admin-x", false},
+		{"arn:aws:iam::2:role/dev", true},
+		{"arn:aws:iam::2:role/admin-y", false},
+		{"arn:aws:iam::3:role/dev", false},
+	}
+
+	for _, test := range tests {
+		if allowed := resourceAllowed(documents, "sts:AssumeRole", test.arn); allowed != test.allowed {
+			t.Errorf("resourceAllowed(%s) = %t, want %t", test.arn, allowed, test.allowed)
+		}
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		s          string
+		ignoreCase bool
+		match      bool
+	}{
+		{"*", "anything", false, true},
+		{"sts:Assume*", "sts:AssumeRole", false, true},
+		{"sts:assumerole", "sts:AssumeRole", true, true},
+		{"sts:assumerole", "sts:AssumeRole", false, false},
+		{"arn:aws:iam::?:role/a", "arn:aws:iam::1:role/a", false, true},
+		{"arn:aws:iam::1:role/a.b", "arn:aws:iam::1:role/aXb", false, false},
+	}
+
+	for _, test := range tests {
+		if match := wildcardMatch(test.pattern, test.s, test.ignoreCase); match != test.match {
+			t.Errorf("wildcardMatch(%q, %q, %t) = %t, want %t", test.pattern, test.s, test.ignoreCase, match, test.match)
+		}
+	}
+}
@@ -2,12 +2,16 @@ package stscreds
 
 import (
 	"fmt"
+	"os"
 	"time"
 )
 
 type ReadCommand struct {
 	Key     string
 	Profile string
+
+	// credentials with less than this left are refreshed
+	MinTTL time.Duration
 }
 
 type ExpiredCredentialsErr string
@@ -16,19 +20,24 @@ func (e ExpiredCredentialsErr) Error() string {
 	return "Credentials have expired"
 }
 
-func (cmd *ReadCommand) Execute() error {
-	limitedCredentials, err := DefaultLimitedAccessCredentials(cmd.Profile)
-	if err != nil {
-		return err
-	}
+// returned when a profile's credentials aren't the ones stscreds wrote
+type OverwrittenCredentialsErr string
 
-	expired, err := limitedCredentials.IsTemporaryCredentialsExpired(time.Now())
+func (e OverwrittenCredentialsErr) Error() string {
+	return fmt.Sprintf("credentials for %s were changed outside stscreds, run stscreds auth --profile=%s to replace them", string(e), string(e))
+}
+
+func (cmd *ReadCommand) Execute() error {
+	state, err := LoadSessionState(cmd.Profile)
 	if err != nil {
 		return err
 	}
 
-	if expired {
-		return ExpiredCredentialsErr(cmd.Profile)
+	if state != nil {
+		err = checkLifetime(state, cmd.MinTTL, Now())
+		if err != nil {
+			return err
+		}
 	}
 
 	creds, err := DefaultTemporaryCredentials(cmd.Profile)
@@ -36,6 +45,16 @@ func (cmd *ReadCommand) Execute() error {
 		return err
 	}
 
+	if state != nil {
+		current, err := creds.Load()
+		if err != nil {
+			return err
+		}
+		if !state.Matches(current) {
+			return OverwrittenCredentialsErr(cmd.Profile)
+		}
+	}
+
 	value, err := creds.Read(cmd.Key)
 	if err != nil {
 		return fmt.Errorf("error reading %s: %s", cmd.Key, err.Error())
@@ -45,3 +64,33 @@ func (cmd *ReadCommand) Execute() error {
 
 	return nil
 }
+
+// how long beyond the minimum lifetime credentials are warned about before
+// they're refreshed
+const ExpiryWarning = 15 * time.Minute
+
+// the minimum remaining lifetime of credentials handed out for profile:
+// minTTL when it's set, otherwise the profile's refresh_before
+func MinimumLifetime(profile string, minTTL time.Duration) (time.Duration, error) {
+	if minTTL > 0 {
+		return minTTL, nil
+	}
+	config, err := DefaultConfig()
+	if err != nil {
+		return 0, err
+	}
+	return config.RefreshBefore(profile)
+}
+
+// treats the session as expired when it has less than minTTL left, and
+// warns when it's getting close
+func checkLifetime(state *SessionState, minTTL time.Duration, now time.Time) error {
+	if !state.Valid(now.Add(minTTL)) {
+		return ExpiredCredentialsErr(state.Profile)
+	}
+	remaining := state.Expiry.Sub(now)
+	if remaining < minTTL+ExpiryWarning {
+		fmt.Fprintf(os.Stderr, "warning: credentials for %s expire in %s\n", state.Profile, remaining.Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package stscreds
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// lists the roles the current user's policies allow them to assume
+type RolesCommand struct {
+	Auth    *AuthCommand
+	Profile string
+
+	// print ready-made role profiles rather than a list of arns
+	AsProfiles bool
+}
+
+func (cmd *RolesCommand) Execute() error {
+	base, err := cmd.Auth.baseSession(cmd.Profile, "")
+	if err != nil {
+		return err
+	}
+
+	user, err := getUser(base.session)
+	if err != nil {
+		return awsError(err, "couldn't request current user")
+	}
+
+	encoded, err := userPolicyDocuments(base.session, *user.UserName)
+	if err != nil {
+		return awsError(err, "error reading policies")
+	}
+
+	var documents []*policyDocument
+	for _, e := range encoded {
+		doc, err := parsePolicyDocument(e)
+		if err != nil {
+			return err
+		}
+		documents = append(documents, doc)
+	}
+
+	resources := allowedResources(documents, "sts:AssumeRole")
+
+	expanded, err := cmd.expand(base, accountID(*user.Arn), resources)
+	if err != nil {
+		return err
+	}
+
+	// denies can name roles matched by an allowed wildcard
+	var arns []string
+	for _, arn := range expanded {
+		if resourceAllowed(documents, "sts:AssumeRole", arn) {
+			arns = append(arns, arn)
+		}
+	}
+
+	if cmd.AsProfiles {
+		cmd.printProfiles(arns)
+		return nil
+	}
+
+	for _, arn := range arns {
+		fmt.Println(arn)
+	}
+	return nil
+}
+
+// expands wildcard resources in the user's own account to the roles they
+// match; wildcards in other accounts are left as they are.
+func (cmd *RolesCommand) expand(base *baseSession, account string, resources []string) ([]string, error) {
+	var arns []string
+	seen := make(map[string]bool)
+	add := func(arn string) {
+		if !seen[arn] {
+			seen[arn] = true
+			arns = append(arns, arn)
+		}
+	}
+
+	var roleArns []string
+	for _, resource := range resources {
+		if !strings.ContainsAny(resource, "*?") {
+			add(resource)
+			continue
+		}
+
+		if resource != "*" && accountID(resource) != account {
+			add(resource)
+			continue
+		}
+
+		if roleArns == nil {
+			roles, err := listRoles(base.session)
+			if err != nil {
+				return nil, awsError(err, "error listing roles")
+			}
+			roleArns = []string{}
+			for _, role := range roles {
+				roleArns = append(roleArns, *role.Arn)
+			}
+		}
+
+		for _, arn := range roleArns {
+			if wildcardMatch(resource, arn, false) {
+				add(arn)
+			}
+		}
+	}
+
+	sort.Strings(arns)
+	return arns, nil
+}
+
+// prints a role profile for every role without wildcards, named after the
+// role and its account
+func (cmd *RolesCommand) printProfiles(arns []string) {
+	for _, arn := range arns {
+		if strings.ContainsAny(arn, "*?") {
+			continue
+		}
+		name := arn[strings.LastIndex(arn, "/")+1:]
+		fmt.Printf("[%s-%s]\n", accountID(arn), name)
+		fmt.Printf("role_arn       = %s\n", arn)
+		fmt.Printf("source_profile = %s\n\n", cmd.Profile)
+	}
+}
+
+// the account id in an arn such as arn:aws:iam::123456789012:role/admin
+func accountID(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[4]
+}
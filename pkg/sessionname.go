@@ -0,0 +1,65 @@
+package stscreds
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"text/template"
+	"time"
+)
+
+// role session name used when a profile doesn't configure session_name
+const DefaultSessionNameTemplate = "{{.Username}}"
+
+// STS limits role session names to 64 characters
+const maxSessionNameLength = 64
+
+var invalidSessionNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// values available to session name templates
+type SessionNameContext struct {
+	Username string
+	Hostname string
+	Profile  string
+	Date     string
+}
+
+func newSessionNameContext(username, profile string, now time.Time) (*SessionNameContext, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("error reading hostname: %s", err.Error())
+	}
+
+	return &SessionNameContext{
+		Username: username,
+		Hostname: hostname,
+		Profile:  profile,
+		Date:     now.UTC().Format("20060102"),
+	}, nil
+}
+
+// renders a session name template, replacing characters STS doesn't allow
+// and truncating it to fit.
+func renderSessionName(text string, ctx *SessionNameContext) (string, error) {
+	tmpl, err := template.New("session_name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid session_name: %s", err.Error())
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, ctx)
+	if err != nil {
+		return "", fmt.Errorf("invalid session_name: %s", err.Error())
+	}
+
+	name := invalidSessionNameChars.ReplaceAllString(buf.String(), "-")
+	if len(name) > maxSessionNameLength {
+		name = name[:maxSessionNameLength]
+	}
+	if len(name) < 2 {
+		return "", fmt.Errorf("session_name %q renders too short a name: %q", text, name)
+	}
+
+	return name, nil
+}
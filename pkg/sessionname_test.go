@@ -0,0 +1,63 @@
+package stscreds
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderSessionName(t *testing.T) {
+	ctx := &SessionNameContext{Username: "alice", Hostname: "laptop.local", Profile: "prod", Date: "20200101"}
+
+	tests := []struct {
+		name     string
+		template string
+		out      string
+	}{
+		{"default", DefaultSessionNameTemplate, "alice"},
+		{"all fields", "{{.Username}}@{{.Hostname}}-{{.Profile}}-{{.Date}}", "alice@laptop.local-prod-20200101"},
+		{"invalid characters replaced", "{{.Username}} on {{.Profile}}/ci", "alice-on-prod-ci"},
+		{"truncated", "{{.Username}}-" + strings.Repeat("x", 70), "alice-" + strings.Repeat("x", 58)},
+	}
+
+	for _, test := range tests {
+		out, err := renderSessionName(test.template, ctx)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+		if out != test.out {
+			t.Errorf("%s: got %q, want %q", test.name, out, test.out)
+		}
+	}
+
+	errorTests := []struct {
+		name     string
+		template string
+	}{
+		{"unknown field", "{{.Team}}"},
+		{"unparseable", "{{.Username"},
+		{"too short", "x"},
+		{"empty", "{{.Profile}}"},
+	}
+
+	empty := &SessionNameContext{}
+	for _, test := range errorTests {
+		if out, err := renderSessionName(test.template, empty); err == nil {
+			t.Errorf("%s: expected an error, got %q", test.name, out)
+		}
+	}
+}
+
+func TestNewSessionNameContext(t *testing.T) {
+	ctx, err := newSessionNameContext("alice", "prod", time.Date(2020, 1, 1, 23, 30, 0, 0, time.FixedZone("", -2*60*60)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Username != "alice" || ctx.Profile != "prod" || ctx.Hostname == "" {
+		t.Errorf("got %+v", ctx)
+	}
+	if ctx.Date != "20200102" {
+		t.Errorf("date %q, want the UTC date 20200102", ctx.Date)
+	}
+}
@@ -0,0 +1,181 @@
+package stscreds
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"time"
+)
+
+// the version of the session state file format. Bump it when the format
+// changes and migrate older versions in LoadSessionState.
+const SessionStateVersion = 1
+
+// what stscreds knows about the temporary credentials it last wrote for a
+// profile, kept in a state file per profile alongside its other files.
+type SessionState struct {
+	Version       int       `json:"version"`
+	Profile       string    `json:"profile"`
+	IssuedAt      time.Time `json:"issued_at"`
+	Expiry        time.Time `json:"expiry"`
+	MFASerial     string    `json:"mfa_serial,omitempty"`
+	RoleArn       string    `json:"role_arn,omitempty"`
+	SourceProfile string    `json:"source_profile,omitempty"`
+	SessionArn    string    `json:"session_arn,omitempty"`
+
+	// identifies the access key written to the shared credentials file
+	// without storing it, so changes made by other tools can be noticed
+	AccessKeyFingerprint string `json:"access_key_fingerprint,omitempty"`
+}
+
+// profile names are escaped so names like ../credentials stay in the state
+// directory
+func sessionStatePath(profile string) (string, error) {
+	return stscredsPath("state", url.PathEscape(profile)+".json")
+}
+
+func accessKeyFingerprint(accessKey string) string {
+	sum := sha256.Sum256([]byte(accessKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// loads the profile's session state, or nil if it has never been
+// authenticated. State recorded by older versions, including the expiry
+// key they kept in the long-term credentials file, is migrated.
+func LoadSessionState(profile string) (*SessionState, error) {
+	path, err := sessionStatePath(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return migrateLegacyExpiry(profile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state SessionState
+	err = json.Unmarshal(contents, &state)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", path, err.Error())
+	}
+	if state.Version > SessionStateVersion {
+		return nil, fmt.Errorf("%s was written by a newer version of stscreds (state version %d)", path, state.Version)
+	}
+	state.Version = SessionStateVersion
+
+	return &state, nil
+}
+
+// moves the expiry recorded in the long-term credentials file by older
+// versions into a state file
+func migrateLegacyExpiry(profile string) (*SessionState, error) {
+	limitedCreds, err := DefaultLimitedAccessCredentials(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	expires, ok, err := limitedCreds.legacyExpiry()
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	state := &SessionState{Version: SessionStateVersion, Profile: profile, Expiry: expires}
+	err = state.Save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = limitedCreds.removeLegacyExpiry()
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+func (s *SessionState) Save() error {
+	_, err := s.save()
+	return err
+}
+
+// saves the state, returning the file as written
+func (s *SessionState) save() ([]byte, error) {
+	path, err := sessionStatePath(s.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	contents = append(contents, '\n')
+
+	unlock, err := lockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	err = backupFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return contents, writeFileAtomic(path, contents, 0600)
+}
+
+// forgets the profile's session, so it's treated as never authenticated
+func RemoveSessionState(profile string) error {
+	path, err := sessionStatePath(profile)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = backupFile(path)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// true when the session's credentials are yet to expire
+func (s *SessionState) Valid(now time.Time) bool {
+	return now.Before(s.Expiry)
+}
+
+// false when credentials differ from those stscreds wrote. State migrated
+// from older versions has no fingerprint and matches anything.
+func (s *SessionState) Matches(credentials *Credentials) bool {
+	if s.AccessKeyFingerprint == "" {
+		return true
+	}
+	return credentials != nil && accessKeyFingerprint(credentials.AccessKey) == s.AccessKeyFingerprint
+}
+
+// true when the profile has a session that's yet to expire
+func hasValidSession(profile string, now time.Time) (bool, error) {
+	state, err := LoadSessionState(profile)
+	if err != nil || state == nil {
+		return false, err
+	}
+	return state.Valid(now), nil
+}
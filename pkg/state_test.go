@@ -0,0 +1,141 @@
+package stscreds
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSessionStatePath(t *testing.T) {
+	dir, cleanup := testHome(t)
+	defer cleanup()
+	stateDir := filepath.Join(dir, ".stscreds", "state")
+
+	tests := []struct {
+		profile string
+		file    string
+	}{
+		{"default", "default.json"},
+		{"prod-admin", "prod-admin.json"},
+		{"../credentials", "..%2Fcredentials.json"},
+		{"a/b", "a%2Fb.json"},
+		{"..", "...json"},
+	}
+
+	for _, test := range tests {
+		path, err := sessionStatePath(test.profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Dir(path) != stateDir || filepath.Base(path) != test.file {
+			t.Errorf("sessionStatePath(%q) = %s, want %s", test.profile, path, filepath.Join(stateDir, test.file))
+		}
+	}
+}
+
+func TestSessionStateSaveLoad(t *testing.T) {
+	_, cleanup := testHome(t)
+	defer cleanup()
+
+	expiry := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	state := &SessionState{Version: SessionStateVersion, Profile: "../escape", Expiry: expiry, RoleArn: "arn:aws:iam::1:role/a"}
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadSessionState("../escape")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded == nil || !loaded.Expiry.Equal(expiry) || loaded.RoleArn != state.RoleArn {
+		t.Fatalf("loaded %+v, want %+v", loaded, state)
+	}
+
+	if err := RemoveSessionState("../escape"); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := LoadSessionState("../escape"); err != nil || loaded != nil {
+		t.Errorf("after removing got %+v, %v", loaded, err)
+	}
+}
+
+func TestSessionStateNewerVersion(t *testing.T) {
+	_, cleanup := testHome(t)
+	defer cleanup()
+
+	path, _ := sessionStatePath("default")
+	os.MkdirAll(filepath.Dir(path), 0700)
+	ioutil.WriteFile(path, []byte(`{"version": 99, "profile": "default"}`), 0600)
+
+	if _, err := LoadSessionState("default"); err == nil {
+		t.Error("expected an error loading a newer version")
+	}
+}
+
+func TestMigrateLegacyExpiry(t *testing.T) {
+	dir, cleanup := testHome(t)
+	defer cleanup()
+
+	credentials := filepath.Join(dir, ".stscreds", "credentials")
+	os.MkdirAll(filepath.Dir(credentials), 0700)
+	ioutil.WriteFile(credentials, []byte("[default]\naws_access_key_id = AKIA\naws_secret_access_key = secret\n"+ExpiresKey+" = 2020-01-01T12:00:00Z\n"), 0600)
+
+	state, err := LoadSessionState("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state == nil || !state.Expiry.Equal(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)) || state.Version != SessionStateVersion {
+		t.Fatalf("migrated %+v", state)
+	}
+
+	contents, _ := ioutil.ReadFile(credentials)
+	if string(contents) != "[default]\naws_access_key_id = AKIA\naws_secret_access_key = secret\n" {
+		t.Errorf("legacy expiry left in credentials: %q", contents)
+	}
+
+	if state, err := LoadSessionState("work"); err != nil || state != nil {
+		t.Errorf("profile without a session got %+v, %v", state, err)
+	}
+}
+
+func TestSessionStateValidAndMatches(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	validTests := []struct {
+		expiry time.Time
+		valid  bool
+	}{
+		{now.Add(time.Minute), true},
+		{now, false},
+		{now.Add(-time.Minute), false},
+	}
+	for _, test := range validTests {
+		if valid := (&SessionState{Expiry: test.expiry}).Valid(now); valid != test.valid {
+			t.Errorf("Valid with expiry %s = %t, want %t", test.expiry, valid, test.valid)
+		}
+	}
+
+	fingerprint := accessKeyFingerprint("ASIAEXAMPLE")
+	matchTests := []struct {
+		name        string
+		fingerprint string
+		credentials *Credentials
+		matches     bool
+	}{
+		{"same key", fingerprint, &Credentials{AccessKey: "ASIAEXAMPLE"}, true},
+		{"other key", fingerprint, &Credentials{AccessKey: "ASIAOTHER"}, false},
+		{"no credentials", fingerprint, nil, false},
+		{"migrated state", "", &Credentials{AccessKey: "ASIAOTHER"}, true},
+	}
+	for _, test := range matchTests {
+		if matches := (&SessionState{AccessKeyFingerprint: test.fingerprint}).Matches(test.credentials); matches != test.matches {
+			t.Errorf("%s: Matches = %t, want %t", test.name, matches, test.matches)
+		}
+	}
+
+	if len(fingerprint) != 16 || fingerprint == accessKeyFingerprint("ASIAOTHER") {
+		t.Errorf("unexpected fingerprint %s", fingerprint)
+	}
+}
@@ -0,0 +1,142 @@
+package stscreds
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// when set stscreds fails instead of prompting; see DetectNonInteractive
+var NonInteractive bool
+
+// environment variables CI systems set
+var ciEnvironment = []string{"CI", "CONTINUOUS_INTEGRATION", "BUILD_NUMBER", "GITHUB_ACTIONS", "GITLAB_CI", "BUILDKITE", "JENKINS_URL", "TF_BUILD"}
+
+// true when running in CI, where there's nobody to answer a prompt
+func DetectNonInteractive() bool {
+	for _, name := range ciEnvironment {
+		if value := os.Getenv(name); value != "" && value != "false" && value != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// returned instead of prompting when running non-interactively
+type InteractionRequiredErr string
+
+func (e InteractionRequiredErr) Error() string {
+	return fmt.Sprintf("%s is needed but stscreds is running non-interactively", string(e))
+}
+
+func isTerminal(fd uintptr) bool {
+	_, err := getTermios(fd)
+	return err == nil
+}
+
+func getTermios(fd uintptr) (*syscall.Termios, error) {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlReadTermios, uintptr(unsafe.Pointer(&termios)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return &termios, nil
+}
+
+func setTermios(fd uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlWriteTermios, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// where questions are asked: the terminal on stdin and stderr, or
+// /dev/tty when stdin isn't a terminal (as in an IDE). Input piped in
+// without a terminal to fall back to is still read from stdin.
+type prompt struct {
+	in     *os.File
+	reader *bufio.Reader
+	out    io.Writer
+	tty    *os.File
+}
+
+// opens the prompt, or fails with InteractionRequiredErr naming what was
+// needed when running non-interactively
+func openPrompt(needed string) (*prompt, error) {
+	if NonInteractive {
+		return nil, InteractionRequiredErr(needed)
+	}
+
+	if !isTerminal(os.Stdin.Fd()) {
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err == nil {
+			return &prompt{in: tty, reader: bufio.NewReader(tty), out: tty, tty: tty}, nil
+		}
+	}
+
+	return &prompt{in: os.Stdin, reader: bufio.NewReader(os.Stdin), out: os.Stderr}, nil
+}
+
+func (p *prompt) Close() {
+	if p.tty != nil {
+		p.tty.Close()
+	}
+}
+
+func (p *prompt) ask(question string) (string, error) {
+	fmt.Fprint(p.out, question)
+	text, err := p.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(text, " \r\n"), nil
+}
+
+// asks for a secret without echoing what's typed. Piped input is read as
+// it is.
+func (p *prompt) askSecret(question string) (string, error) {
+	fd := p.in.Fd()
+	old, err := getTermios(fd)
+	if err != nil {
+		return p.ask(question)
+	}
+
+	hidden := *old
+	hidden.Lflag &^= syscall.ECHO
+	hidden.Lflag |= syscall.ICANON | syscall.ISIG
+	hidden.Iflag |= syscall.ICRNL
+	err = setTermios(fd, &hidden)
+	if err != nil {
+		return "", err
+	}
+	defer setTermios(fd, old)
+
+	// Ctrl-C would otherwise exit before the deferred restore, leaving the
+	// terminal without echo
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	defer func() {
+		signal.Stop(signals)
+		close(done)
+	}()
+	go func() {
+		select {
+		case sig := <-signals:
+			setTermios(fd, old)
+			fmt.Fprintln(p.out)
+			os.Exit(128 + int(sig.(syscall.Signal)))
+		case <-done:
+		}
+	}()
+
+	answer, err := p.ask(question)
+	fmt.Fprintln(p.out)
+	return answer, err
+}
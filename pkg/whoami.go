@@ -21,12 +21,12 @@ func (w *WhoAmI) Execute() error {
 
 	user, err := getUser(sess)
 	if err != nil {
-		return err
+		return awsError(err, "couldn't request current user")
 	}
 
 	devices, err := mfaDevices(sess, *user.UserName)
 	if err != nil {
-		return err
+		return awsError(err, "couldn't list mfa devices")
 	}
 
 	fmt.Printf("%+v\n", user)